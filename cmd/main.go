@@ -12,18 +12,17 @@ func main() {
     
     // Create breathing system
     breathingSystem := ecs.NewBreathingSystem(world)
-    world.AddSystem(breathingSystem)
+    world.AddSystem(breathingSystem, ecs.InStage(ecs.StageFixedUpdate))
+    world.SetFixedTimestep(0.1, 5)
     
     // Subscribe to breath events
-    world.EventManager.Subscribe("EntityBreathed", func(data interface{}) {
-        if event, ok := data.(ecs.BreathEvent); ok {
-            state := "exhaling"
-            if event.State == components.Inhale {
-                state = "inhaling"
-            }
-            fmt.Printf("Entity %d is %s (Volume: %.2f)\n", 
-                event.Entity, state, event.Volume)
+    ecs.Subscribe(world.EventManager, func(event ecs.BreathEvent) {
+        state := "exhaling"
+        if event.State == components.Inhale {
+            state = "inhaling"
         }
+        fmt.Printf("Entity %d is %s (Volume: %.2f)\n", 
+            event.Entity, state, event.Volume)
     })
     
     // Create entity with components
@@ -37,13 +36,16 @@ func main() {
         IsOpen: true,
     })
     
-    // Simulation loop
-    ticker := time.NewTicker(100 * time.Millisecond)
+    // Simulation loop: frames follow the wall clock, breathing steps at a
+    // fixed 0.1s regardless of frame jitter.
+    ticker := time.NewTicker(16 * time.Millisecond)
     defer ticker.Stop()
     
     fmt.Println("Starting breathing simulation...")
-    for i := 0; i < 50; i++ {
-        <-ticker.C
-        world.Update(0.1)
+    last := time.Now()
+    for start := last; time.Since(start) < 5*time.Second; {
+        now := <-ticker.C
+        world.RunFrame(float32(now.Sub(last).Seconds()))
+        last = now
     }
 }
\ No newline at end of file
@@ -3,15 +3,187 @@
 package ecs
 
 import (
+	"encoding/binary"
+	"hash/fnv"
 	"reflect"
-    "github.com/AMMPTT/strux/pkg/components"
+	"sort"
 
+	"github.com/AMMPTT/strux/pkg/components"
 )
 
 type Component = components.ComponentData
 
+// ComponentID is a dense, per-EntityManager identifier for a component type,
+// assigned in registration order. Archetype type sets are sorted by it.
+type ComponentID uint32
+
+// ArchetypeID is the position of an archetype in EntityManager.archetypes.
+// The empty archetype, which holds entities without components, is always 0.
+type ArchetypeID uint32
+
+// componentTicks records the change ticks at which a component was added and
+// last changed.
+type componentTicks struct {
+	added   uint64
+	changed uint64
+}
+
+// Archetype is a table holding every entity with exactly one set of
+// component types. Each component type has a column and each entity a row,
+// so components[c][r] belongs to entities[r] and ticks[c][r] tracks it.
 type Archetype struct {
-    componentTypes []reflect.Type
-    entities       []Entity
-    components     [][]Component
-}
\ No newline at end of file
+	id             ArchetypeID
+	componentIDs   []ComponentID
+	componentTypes []reflect.Type
+	entities       []Entity
+	components     [][]Component
+	ticks          [][]componentTicks
+	columns        map[reflect.Type]int
+	mask           componentMask
+
+	// Cached transitions to the archetype with one component type added or
+	// removed, filled in lazily as entities move through the graph.
+	addEdges    map[ComponentID]*Archetype
+	removeEdges map[ComponentID]*Archetype
+}
+
+func newArchetype(id ArchetypeID, componentIDs []ComponentID, componentTypes []reflect.Type) *Archetype {
+	archetype := &Archetype{
+		id:             id,
+		componentIDs:   componentIDs,
+		componentTypes: componentTypes,
+		entities:       make([]Entity, 0),
+		components:     make([][]Component, len(componentTypes)),
+		ticks:          make([][]componentTicks, len(componentTypes)),
+		columns:        make(map[reflect.Type]int, len(componentTypes)),
+		addEdges:       make(map[ComponentID]*Archetype),
+		removeEdges:    make(map[ComponentID]*Archetype),
+	}
+	for i, t := range componentTypes {
+		archetype.columns[t] = i
+	}
+	for _, id := range componentIDs {
+		archetype.mask.set(id)
+	}
+	return archetype
+}
+
+// ID returns the archetype's identifier.
+func (a *Archetype) ID() ArchetypeID {
+	return a.id
+}
+
+// Len returns the number of entities stored in the archetype.
+func (a *Archetype) Len() int {
+	return len(a.entities)
+}
+
+// column returns the index of the component type's column, or -1 if the
+// archetype does not store that type.
+func (a *Archetype) column(componentType reflect.Type) int {
+	if i, exists := a.columns[componentType]; exists {
+		return i
+	}
+	return -1
+}
+
+// swapRemove deletes a row by moving the last row into its place. It returns
+// the entity that now occupies the row, if any row was moved.
+func (a *Archetype) swapRemove(row int) (Entity, bool) {
+	lastIdx := len(a.entities) - 1
+	moved := a.entities[lastIdx]
+	a.entities[row] = moved
+	a.entities = a.entities[:lastIdx]
+	for j := range a.components {
+		a.components[j][row] = a.components[j][lastIdx]
+		a.components[j][lastIdx] = nil
+		a.components[j] = a.components[j][:lastIdx]
+		a.ticks[j][row] = a.ticks[j][lastIdx]
+		a.ticks[j] = a.ticks[j][:lastIdx]
+	}
+	return moved, row != lastIdx
+}
+
+// componentMask is a bitset of ComponentIDs. Every archetype carries the mask
+// of its type set so query terms can be matched with a few word operations.
+type componentMask []uint64
+
+func (m *componentMask) set(id ComponentID) {
+	word := int(id / 64)
+	for len(*m) <= word {
+		*m = append(*m, 0)
+	}
+	(*m)[word] |= 1 << (id % 64)
+}
+
+func (m componentMask) has(id ComponentID) bool {
+	word := int(id / 64)
+	return word < len(m) && m[word]&(1<<(id%64)) != 0
+}
+
+// containsAll reports whether every bit of other is set in m.
+func (m componentMask) containsAll(other componentMask) bool {
+	for i, bits := range other {
+		var mine uint64
+		if i < len(m) {
+			mine = m[i]
+		}
+		if mine&bits != bits {
+			return false
+		}
+	}
+	return true
+}
+
+// intersects reports whether m and other share any bit.
+func (m componentMask) intersects(other componentMask) bool {
+	for i := 0; i < len(m) && i < len(other); i++ {
+		if m[i]&other[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// archetypeHash hashes a canonically sorted component ID set.
+func archetypeHash(componentIDs []ComponentID) uint64 {
+	h := fnv.New64a()
+	var buf [4]byte
+	for _, id := range componentIDs {
+		binary.LittleEndian.PutUint32(buf[:], uint32(id))
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+func sameComponentIDs(a, b []ComponentID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// withComponentID returns a sorted copy of ids with id inserted.
+func withComponentID(ids []ComponentID, id ComponentID) []ComponentID {
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	result := make([]ComponentID, 0, len(ids)+1)
+	result = append(result, ids[:i]...)
+	result = append(result, id)
+	return append(result, ids[i:]...)
+}
+
+// withoutComponentID returns a copy of ids with id removed.
+func withoutComponentID(ids []ComponentID, id ComponentID) []ComponentID {
+	result := make([]ComponentID, 0, len(ids))
+	for _, other := range ids {
+		if other != id {
+			result = append(result, other)
+		}
+	}
+	return result
+}
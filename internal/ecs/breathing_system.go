@@ -7,46 +7,51 @@ import (
 )
 
 type BreathingSystem struct {
-    world *World
+    world  *World
+    query  *Query2[*components.Lung, *components.Mouth]
+    events *EventWriter[BreathEvent]
 }
 
 func NewBreathingSystem(world *World) *BreathingSystem {
-    return &BreathingSystem{world: world}
+    return &BreathingSystem{
+        world:  world,
+        query:  NewQuery2[*components.Lung, *components.Mouth](world),
+        events: NewEventWriter[BreathEvent](world),
+    }
+}
+
+// Access declares that the system writes lungs and mouths.
+func (s *BreathingSystem) Access() Access {
+    return Access{
+        Writes: []reflect.Type{TypeOf[*components.Lung](), TypeOf[*components.Mouth]()},
+    }
 }
 
+// BreathEvent is published whenever an entity's lung changes, and sent to
+// the world's BreathEvent queue for EventReaders.
 type BreathEvent struct {
     Entity Entity
     State  components.LungState
     Volume float32
 }
 
-func (s *BreathingSystem) Update(dt float32) {
-    s.world.mu.RLock()
-    defer s.world.mu.RUnlock()
-
-    lungArray := s.world.components[reflect.TypeOf(&components.Lung{})]
-    mouthArray := s.world.components[reflect.TypeOf(&components.Mouth{})]
-
-    if lungArray == nil || mouthArray == nil {
-        return
-    }
+// EventName keeps BreathEvent on the "EntityBreathed" name used by string
+// subscribers.
+func (BreathEvent) EventName() string {
+    return "EntityBreathed"
+}
 
-    lungArray.Lock()
-    mouthArray.Lock()
-    defer lungArray.Unlock()
-    defer mouthArray.Unlock()
+func (e BreathEvent) EventEntity() Entity {
+    return e.Entity
+}
 
-    for i := 0; i < lungArray.Size; i++ {
-        entityIndex := i
-        lung, ok := lungArray.Data[i].(*components.Lung)
-        if !ok {
-            continue
-        }
-        mouth, ok := mouthArray.Data[entityIndex].(*components.Mouth)
-        if !ok {
-            continue
-        }
+func (s *BreathingSystem) Update(dt float32) {
+    var events []BreathEvent
 
+    it := s.query.Iter()
+    defer it.Close()
+    for it.Next() {
+        entity, lung, mouth := it.Get()
         previousState := lung.State
         previousVolume := lung.Volume
 
@@ -69,11 +74,22 @@ func (s *BreathingSystem) Update(dt float32) {
 
         // Emit event if state changed
         if lung.State != previousState || lung.Volume != previousVolume {
-            s.world.EventManager.Publish("EntityBreathed", BreathEvent{
-                Entity: Entity(entityIndex),
+            it.MarkChanged(0)
+            if lung.State != previousState {
+                it.MarkChanged(1)
+            }
+            events = append(events, BreathEvent{
+                Entity: entity,
                 State:  lung.State,
                 Volume: lung.Volume,
             })
         }
     }
+
+    // Publish once iteration has released the storage lock so subscribers
+    // may touch the world.
+    for _, event := range events {
+        s.events.Send(event)
+        Publish(s.world.EventManager, event)
+    }
 }
\ No newline at end of file
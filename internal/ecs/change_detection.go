@@ -0,0 +1,143 @@
+// internal/ecs/change_detection.go
+
+package ecs
+
+import (
+	"reflect"
+)
+
+// Every component row carries the change tick at which it was added and last
+// changed. Writes through AddComponent, UpdateComponent, MarkChanged and
+// GetMut stamp the current change tick; components mutated in place through
+// a pointer must be marked explicitly. A query with Added or Changed terms
+// advances the change tick each time it runs and only yields rows stamped
+// after its previous run.
+
+// Tick returns the world tick, which World.Update advances once per frame.
+func (em *EntityManager) Tick() uint64 {
+	return em.tick.Load()
+}
+
+// AdvanceTick starts a new world tick and drops removal records older than
+// the previous tick.
+func (em *EntityManager) AdvanceTick() uint64 {
+	em.lock()
+	defer em.mu.Unlock()
+
+	tick := em.tick.Add(1)
+	em.changeTick.Add(1)
+	for _, log := range em.removed {
+		log.trim(tick)
+	}
+	return tick
+}
+
+// MarkChanged stamps the entity's component of the given type as changed. It
+// only takes the read lock, like an in-place write through a pointer.
+func (em *EntityManager) MarkChanged(entity Entity, componentType reflect.Type) {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	em.checkAccess(componentType, true)
+	if record := em.record(entity); record != nil {
+		if column := record.archetype.column(componentType); column >= 0 {
+			record.archetype.ticks[column][record.row].changed = em.changeTick.Load()
+			em.journal.markDirty(entity, componentType)
+		}
+	}
+}
+
+// MarkChanged stamps the current entity's i-th fetched component as changed.
+func (it *QueryIter) MarkChanged(i int) {
+	it.query.em.checkAccess(it.query.fetch[i], true)
+	match := &it.query.matched[it.match]
+	if column := match.columns[i]; column >= 0 {
+		match.archetype.ticks[column][it.row].changed = it.query.em.changeTick.Load()
+		it.query.em.journal.markDirty(it.Entity(), it.query.fetch[i])
+	}
+}
+
+// MarkChanged stamps the entity's T as changed.
+func MarkChanged[T Component](w *World, entity Entity) {
+	w.entityManager.MarkChanged(entity, TypeOf[T]())
+}
+
+// GetMut returns the entity's T and marks it changed.
+func GetMut[T Component](w *World, entity Entity) (T, bool) {
+	component, ok := Get[T](w, entity)
+	if ok {
+		w.entityManager.MarkChanged(entity, TypeOf[T]())
+	}
+	return component, ok
+}
+
+// removalLog keeps the entities that lost a component type during the
+// current and previous world tick. Readers hold an absolute sequence number
+// into it so trimming does not invalidate their position.
+type removalLog struct {
+	entities []Entity
+	ticks    []uint64
+	start    uint64 // sequence number of entities[0]
+}
+
+func (l *removalLog) trim(tick uint64) {
+	drop := 0
+	for drop < len(l.ticks) && l.ticks[drop]+1 < tick {
+		drop++
+	}
+	l.entities = append(l.entities[:0], l.entities[drop:]...)
+	l.ticks = append(l.ticks[:0], l.ticks[drop:]...)
+	l.start += uint64(drop)
+}
+
+// recordRemoval logs a removal for types that have a RemovedComponents
+// reader. Callers must hold the write lock.
+func (em *EntityManager) recordRemoval(componentType reflect.Type, entity Entity) {
+	if log, exists := em.removed[componentType]; exists {
+		log.entities = append(log.entities, entity)
+		log.ticks = append(log.ticks, em.tick.Load())
+	}
+}
+
+// RemovedComponents reads the entities that lost their T, through
+// RemoveComponent or DestroyEntity, since the reader last read. Removals are
+// kept for two world ticks, so a reader must run at least once per tick to
+// see all of them.
+type RemovedComponents[T Component] struct {
+	em     *EntityManager
+	log    *removalLog
+	cursor uint64
+}
+
+// NewRemovedComponents starts tracking removals of T. Removals that happened
+// before the reader was created are not reported.
+func NewRemovedComponents[T Component](w *World) *RemovedComponents[T] {
+	em := w.entityManager
+	em.lock()
+	defer em.mu.Unlock()
+
+	componentType := TypeOf[T]()
+	log, exists := em.removed[componentType]
+	if !exists {
+		log = &removalLog{}
+		em.removed[componentType] = log
+	}
+	return &RemovedComponents[T]{em: em, log: log, cursor: log.start + uint64(len(log.entities))}
+}
+
+// Read returns the removals since the previous Read.
+func (r *RemovedComponents[T]) Read() []Entity {
+	r.em.mu.RLock()
+	defer r.em.mu.RUnlock()
+
+	from := r.cursor
+	if from < r.log.start {
+		from = r.log.start
+	}
+	end := r.log.start + uint64(len(r.log.entities))
+	r.cursor = end
+	if from >= end {
+		return nil
+	}
+	return append([]Entity(nil), r.log.entities[from-r.log.start:]...)
+}
@@ -0,0 +1,119 @@
+package ecs
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+// run runs the query once and returns the entities it yielded, sorted.
+func run(q *Query) []Entity {
+	entities := q.Entities()
+	sort.Slice(entities, func(i, j int) bool { return entities[i] < entities[j] })
+	return entities
+}
+
+func requireRun(t *testing.T, name string, q *Query, want ...Entity) {
+	t.Helper()
+	got := run(q)
+	if len(got) != len(want) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+		t.Errorf("%s yielded %v, want %v", name, got, want)
+	}
+}
+
+func TestAddedAndChangedQueries(t *testing.T) {
+	w := NewWorld()
+	lung := TypeOf[*components.Lung]()
+	first, second := w.CreateEntity(), w.CreateEntity()
+	w.AddComponent(first, &components.Lung{})
+	w.AddComponent(second, &components.Lung{})
+	added := w.NewQuery().Added(lung).Build()
+	changed := w.NewQuery().Changed(lung).Build()
+
+	// Adding counts as a change too.
+	requireRun(t, "first Added run", added, first, second)
+	requireRun(t, "first Changed run", changed, first, second)
+	requireRun(t, "Added run without writes", added)
+	requireRun(t, "Changed run without writes", changed)
+
+	w.UpdateComponent(first, &components.Lung{Volume: 1})
+	requireRun(t, "Added after an update", added)
+	requireRun(t, "Changed after an update", changed, first)
+
+	third := w.CreateEntity()
+	w.AddComponent(third, &components.Lung{})
+	requireRun(t, "Added after an add", added, third)
+	requireRun(t, "Changed after an add", changed, third)
+}
+
+func TestMarkChangedAndGetMut(t *testing.T) {
+	w := NewWorld()
+	first, second := w.CreateEntity(), w.CreateEntity()
+	w.AddComponent(first, &components.Lung{})
+	w.AddComponent(second, &components.Lung{})
+	changed := w.NewQuery().Changed(TypeOf[*components.Lung]()).Build()
+	run(changed)
+
+	// A plain Get and an in-place write are invisible.
+	lung, _ := Get[*components.Lung](w, first)
+	lung.Volume = 0.5
+	requireRun(t, "Changed after an unmarked write", changed)
+
+	MarkChanged[*components.Lung](w, second)
+	requireRun(t, "Changed after MarkChanged", changed, second)
+
+	lung, _ = GetMut[*components.Lung](w, first)
+	lung.Volume = 0.75
+	requireRun(t, "Changed after GetMut", changed, first)
+}
+
+func TestChangeQueriesKeepTheirOwnLastRun(t *testing.T) {
+	w := NewWorld()
+	first, second := w.CreateEntity(), w.CreateEntity()
+	w.AddComponent(first, &components.Lung{})
+	w.AddComponent(second, &components.Lung{})
+	lung := TypeOf[*components.Lung]()
+	often := w.NewQuery().Changed(lung).Build()
+	rarely := w.NewQuery().Changed(lung).Build()
+	run(often)
+	run(rarely)
+
+	MarkChanged[*components.Lung](w, first)
+	requireRun(t, "frequent query", often, first)
+	MarkChanged[*components.Lung](w, second)
+	requireRun(t, "frequent query", often, second)
+	requireRun(t, "infrequent query", rarely, first, second)
+}
+
+func TestRemovedComponents(t *testing.T) {
+	w := NewWorld()
+	kept, destroyed, stripped := w.CreateEntity(), w.CreateEntity(), w.CreateEntity()
+	for _, entity := range []Entity{kept, destroyed, stripped} {
+		w.AddComponent(entity, &components.Lung{})
+	}
+	reader := NewRemovedComponents[*components.Lung](w)
+	late := NewRemovedComponents[*components.Lung](w)
+
+	w.DestroyEntity(destroyed)
+	w.RemoveComponent(stripped, TypeOf[*components.Lung]())
+	w.RemoveComponent(kept, TypeOf[*components.Mouth]()) // not attached: no removal
+	w.Update(0.1)
+
+	if got, want := reader.Read(), []Entity{destroyed, stripped}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Read = %v, want %v", got, want)
+	}
+	if got := reader.Read(); len(got) != 0 {
+		t.Errorf("second Read = %v, want nothing", got)
+	}
+	if got := NewRemovedComponents[*components.Lung](w).Read(); len(got) != 0 {
+		t.Errorf("reader created afterwards saw %v", got)
+	}
+
+	// Removals are kept for the tick they happened in and the next one.
+	w.Update(0.1)
+	if got := late.Read(); len(got) != 0 {
+		t.Errorf("removals still readable two ticks later: %v", got)
+	}
+}
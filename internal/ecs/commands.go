@@ -0,0 +1,163 @@
+// internal/ecs/commands.go
+
+package ecs
+
+import (
+	"reflect"
+	"sync"
+)
+
+type commandKind int
+
+const (
+	commandSpawn commandKind = iota
+	commandDespawn
+	commandInsert
+	commandRemove
+)
+
+type command struct {
+	kind          commandKind
+	entity        Entity
+	components    []Component
+	componentType reflect.Type
+}
+
+// Commands records structural changes so systems can request them while
+// iterating, when taking the EntityManager write lock would deadlock.
+// Recording never touches component storage; the changes are made, in the
+// order recorded, when the buffer is applied.
+//
+// The scheduler applies buffers at the end of every stage: first those of
+// the stage's systems in schedule order, then the world's own buffer.
+type Commands struct {
+	world    *World
+	commands []command
+	mu       sync.Mutex
+}
+
+// CommandSystem is implemented by systems that make structural changes. The
+// scheduler hands each such system its own buffer when it is added.
+type CommandSystem interface {
+	System
+	SetCommands(commands *Commands)
+}
+
+// NewCommands creates a buffer that is only applied by calling Apply.
+func NewCommands(w *World) *Commands {
+	return &Commands{world: w}
+}
+
+// Spawn reserves an entity immediately and adds the given components when
+// the buffer is applied. The handle can be used straight away, in commands
+// of any buffer or directly: IsAlive reports true, and the entity is stored,
+// without components, the first time a component is added to it.
+func (c *Commands) Spawn(components ...Component) Entity {
+	entity := c.world.entityManager.ReserveEntity()
+	c.push(command{kind: commandSpawn, entity: entity, components: components})
+	return entity
+}
+
+// Despawn destroys the entity when the buffer is applied.
+func (c *Commands) Despawn(entity Entity) {
+	c.push(command{kind: commandDespawn, entity: entity})
+}
+
+// Insert adds or replaces a component when the buffer is applied.
+func (c *Commands) Insert(entity Entity, component Component) {
+	c.push(command{kind: commandInsert, entity: entity, components: []Component{component}})
+}
+
+// Remove removes a component when the buffer is applied.
+func (c *Commands) Remove(entity Entity, componentType reflect.Type) {
+	c.push(command{kind: commandRemove, entity: entity, componentType: componentType})
+}
+
+func (c *Commands) push(cmd command) {
+	c.mu.Lock()
+	c.commands = append(c.commands, cmd)
+	c.mu.Unlock()
+}
+
+// Len returns the number of pending commands.
+func (c *Commands) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.commands)
+}
+
+// Apply performs and clears the pending commands. Inserts and removes on
+// entities that are no longer alive are dropped. It must not be called while
+// a query is being iterated.
+func (c *Commands) Apply() {
+	c.mu.Lock()
+	commands := c.commands
+	c.commands = nil
+	c.mu.Unlock()
+
+	w := c.world
+	for _, cmd := range commands {
+		switch cmd.kind {
+		case commandSpawn:
+			w.entityManager.SpawnReserved(cmd.entity, cmd.components...)
+		case commandDespawn:
+			w.DestroyEntity(cmd.entity)
+		case commandInsert:
+			if w.IsAlive(cmd.entity) {
+				w.AddComponent(cmd.entity, cmd.components[0])
+			}
+		case commandRemove:
+			w.RemoveComponent(cmd.entity, cmd.componentType)
+		}
+	}
+}
+
+// Commands returns the world's own buffer, applied after the systems'
+// buffers at the end of every stage.
+func (w *World) Commands() *Commands {
+	return w.commands
+}
+
+// ReserveEntity allocates a handle without storing the entity. It does not
+// take the storage lock, so it is safe while queries are iterating. The
+// entity is created by SpawnReserved.
+func (em *EntityManager) ReserveEntity() Entity {
+	return em.entities.allocate()
+}
+
+// SpawnReserved adds the components to a reserved entity, storing it first
+// if nothing has yet. It does nothing if the entity was destroyed in the
+// meantime.
+func (em *EntityManager) SpawnReserved(entity Entity, components ...Component) {
+	em.lock()
+	defer em.mu.Unlock()
+
+	record := em.storedRecord(entity)
+	if record == nil {
+		return
+	}
+	for _, component := range components {
+		em.insert(record, reflect.TypeOf(component), component)
+		em.journal.logComponent(recordAdd, entity, component)
+	}
+}
+
+// storedRecord returns the entity's record like record, first storing a
+// reserved entity in the empty archetype. Callers must hold the write lock.
+func (em *EntityManager) storedRecord(entity Entity) *entityRecord {
+	if record := em.record(entity); record != nil {
+		return record
+	}
+	if !em.entities.isAlive(entity) {
+		return nil
+	}
+	em.place(entity)
+	em.journal.logEntity(recordCreate, entity)
+	return em.record(entity)
+}
+
+func (em *EntityManager) hasRecord(entity Entity) bool {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	return em.record(entity) != nil
+}
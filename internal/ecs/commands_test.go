@@ -0,0 +1,101 @@
+package ecs
+
+import (
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+// commandSystem runs a function with its own command buffer.
+type commandSystem struct {
+	commands *Commands
+	update   func(*Commands)
+}
+
+func (s *commandSystem) Update(float32)                 { s.update(s.commands) }
+func (s *commandSystem) SetCommands(commands *Commands) { s.commands = commands }
+
+func TestCommandsApplyInRecordedOrder(t *testing.T) {
+	w, entities := populatedWorld(t)
+	c := NewCommands(w)
+	spawned := c.Spawn(&components.Mouth{IsOpen: true})
+	c.Insert(spawned, &components.Lung{Capacity: 2})
+	c.Remove(spawned, TypeOf[*components.Mouth]())
+	c.Insert(spawned, &components.Mouth{})
+	c.Despawn(entities[2])
+	c.Insert(entities[2], &components.Lung{}) // dropped: despawned first
+	c.Remove(entities[0], TypeOf[*components.Lung]())
+
+	if c.Len() != 7 {
+		t.Fatalf("Len = %d, want 7", c.Len())
+	}
+	if _, ok := Get[*components.Lung](w, entities[0]); !ok {
+		t.Fatal("recording changed the world")
+	}
+	c.Apply()
+
+	if lung, ok := Get[*components.Lung](w, spawned); !ok || lung.Capacity != 2 {
+		t.Errorf("spawned entity lung = %v, %v", lung, ok)
+	}
+	if mouth, ok := Get[*components.Mouth](w, spawned); !ok || mouth.IsOpen {
+		t.Errorf("spawned entity mouth = %v, %v; want the reinserted closed mouth", mouth, ok)
+	}
+	if w.IsAlive(entities[2]) {
+		t.Error("despawned entity is alive")
+	}
+	if Has[*components.Lung](w, entities[0]) {
+		t.Error("removed component is still attached")
+	}
+	if c.Len() != 0 {
+		t.Errorf("%d commands left after Apply", c.Len())
+	}
+}
+
+func TestInsertIntoEntitySpawnedByLaterBuffer(t *testing.T) {
+	w := NewWorld()
+	// The world's buffer is applied after the system's, in the same sync
+	// point.
+	spawned := w.Commands().Spawn(&components.Mouth{IsOpen: true})
+	w.AddSystem(&commandSystem{update: func(c *Commands) {
+		c.Insert(spawned, &components.Lung{Capacity: 3})
+	}})
+	w.Update(0.1)
+
+	if !Has[*components.Lung](w, spawned) || !Has[*components.Mouth](w, spawned) {
+		t.Error("insert into an entity spawned by a later buffer was dropped")
+	}
+}
+
+func TestReservedEntityUsableDirectly(t *testing.T) {
+	w := NewWorld()
+	spawned := w.Commands().Spawn(&components.Mouth{IsOpen: true})
+	if !w.IsAlive(spawned) {
+		t.Fatal("reserved entity is not alive")
+	}
+
+	w.AddComponent(spawned, &components.Lung{Capacity: 1})
+	if lung, ok := Get[*components.Lung](w, spawned); !ok || lung.Capacity != 1 {
+		t.Fatalf("component added to reserved entity = %v, %v", lung, ok)
+	}
+	w.Update(0.1)
+	if !Has[*components.Lung](w, spawned) || !Has[*components.Mouth](w, spawned) {
+		t.Error("spawn did not keep the directly added component")
+	}
+	if got := len(w.Query()); got != 1 {
+		t.Errorf("%d entities stored, want 1", got)
+	}
+}
+
+func TestDespawnBeforeSpawnIsApplied(t *testing.T) {
+	w := NewWorld()
+	spawned := w.Commands().Spawn(&components.Mouth{})
+	w.DestroyEntity(spawned)
+	w.Update(0.1)
+
+	if w.IsAlive(spawned) || Has[*components.Mouth](w, spawned) {
+		t.Error("entity destroyed before its spawn was applied came back")
+	}
+	if next := w.CreateEntity(); next.Index() != spawned.Index() || next == spawned {
+		t.Errorf("CreateEntity = %d, want slot %d reused with a new version", next, spawned.Index())
+	}
+}
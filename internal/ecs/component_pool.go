@@ -29,7 +29,7 @@ func (cp *ComponentPool) Get() Component {
 
 	if len(cp.pool) == 0 {
 		// Create a new component if the pool is empty
-		return reflect.New(cp.componentType).Interface().(Component)
+		return newComponent(cp.componentType)
 	}
 
 	// Remove and return the last component from the pool
@@ -38,7 +38,8 @@ func (cp *ComponentPool) Get() Component {
 	return component
 }
 
-// Return puts a component back into the pool
+// Return puts a component back into the pool, resetting pointer components to
+// their zero value so the next Get starts from a clean state
 func (cp *ComponentPool) Return(component Component) {
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
@@ -48,6 +49,10 @@ func (cp *ComponentPool) Return(component Component) {
 		panic("Attempted to return component of wrong type to pool")
 	}
 
+	if value := reflect.ValueOf(component); value.Kind() == reflect.Ptr && !value.IsNil() {
+		value.Elem().Set(reflect.Zero(value.Elem().Type()))
+	}
+
 	cp.pool = append(cp.pool, component)
 }
 
@@ -56,4 +61,14 @@ func (cp *ComponentPool) Size() int {
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
 	return len(cp.pool)
-}
\ No newline at end of file
+}
+
+// newComponent allocates a zero value of the component type. Components are
+// usually pointer types such as *components.Lung, in which case the pointee is
+// allocated rather than a pointer to a nil pointer.
+func newComponent(componentType reflect.Type) Component {
+	if componentType.Kind() == reflect.Ptr {
+		return reflect.New(componentType.Elem()).Interface().(Component)
+	}
+	return reflect.New(componentType).Elem().Interface().(Component)
+}
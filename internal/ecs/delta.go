@@ -0,0 +1,434 @@
+// internal/ecs/delta.go
+
+package ecs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+// Snapshot is an in-memory capture of a world's entities and components, the
+// base deltas are computed against. Components are held in the binary
+// snapshot encoding, so captures do not alias the world's values and two
+// versions of a component are compared byte for byte. Resources are not
+// captured.
+type Snapshot struct {
+	tick      uint64
+	allocator allocatorState
+	entities  map[Entity]map[string][]byte // component type name -> encoding
+	types     map[string]*typeDescriptor
+}
+
+// Snapshot captures the world's current entities and components. Every
+// component type must be registered with components.Register.
+func (w *World) Snapshot() (*Snapshot, error) {
+	em := w.entityManager
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	s := &Snapshot{
+		tick:      em.tick.Load(),
+		allocator: em.entities.state(),
+		entities:  make(map[Entity]map[string][]byte),
+		types:     make(map[string]*typeDescriptor),
+	}
+	var buf bytes.Buffer
+	sw := &snapshotWriter{w: &buf}
+	for _, archetype := range em.archetypes {
+		descriptors := make([]*typeDescriptor, len(archetype.componentTypes))
+		for i, compType := range archetype.componentTypes {
+			name, registered := components.TypeName(compType)
+			if !registered {
+				return nil, fmt.Errorf("component type %v is not registered", compType)
+			}
+			if s.types[name] == nil {
+				descriptor, err := describeType(name, compType)
+				if err != nil {
+					return nil, err
+				}
+				s.types[name] = descriptor
+			}
+			descriptors[i] = s.types[name]
+		}
+
+		for row, entity := range archetype.entities {
+			owned := make(map[string][]byte, len(descriptors))
+			for i, descriptor := range descriptors {
+				buf.Reset()
+				sw.component(descriptor, archetype.components[i][row])
+				owned[descriptor.name] = append([]byte(nil), buf.Bytes()...)
+			}
+			s.entities[entity] = owned
+		}
+	}
+	return s, nil
+}
+
+// Tick returns the world tick at which the snapshot was taken.
+func (s *Snapshot) Tick() uint64 {
+	return s.tick
+}
+
+// Len returns the number of entities captured.
+func (s *Snapshot) Len() int {
+	return len(s.entities)
+}
+
+// ComponentRef names one component of one entity.
+type ComponentRef struct {
+	Entity Entity
+	Type   string // registered component type name
+}
+
+// Delta is the difference between two snapshots. Applied to a world in the
+// state of the first snapshot, it brings the world's entities and
+// components to the state of the second. Entity lists are sorted by handle
+// and component lists by handle, then type name.
+type Delta struct {
+	BaseTick uint64 // tick of the snapshot the delta applies to
+	Tick     uint64 // tick of the snapshot the delta leads to
+
+	Spawned   []Entity
+	Despawned []Entity
+	Added     []ComponentRef // including every component of spawned entities
+	Modified  []ComponentRef
+	Removed   []ComponentRef // not listed for despawned entities
+
+	allocator allocatorState
+	types     map[string]*typeDescriptor
+	data      map[ComponentRef][]byte // encodings of Added and Modified
+}
+
+// Diff computes the delta that turns from into to.
+func Diff(from, to *Snapshot) *Delta {
+	d := &Delta{
+		BaseTick:  from.tick,
+		Tick:      to.tick,
+		allocator: to.allocator,
+		types:     make(map[string]*typeDescriptor),
+		data:      make(map[ComponentRef][]byte),
+	}
+	for name, descriptor := range from.types {
+		d.types[name] = descriptor
+	}
+	for name, descriptor := range to.types {
+		d.types[name] = descriptor
+	}
+
+	for entity, before := range from.entities {
+		after, exists := to.entities[entity]
+		if !exists {
+			d.Despawned = append(d.Despawned, entity)
+			continue
+		}
+		for name, data := range after {
+			ref := ComponentRef{entity, name}
+			if old, had := before[name]; !had {
+				d.Added = append(d.Added, ref)
+				d.data[ref] = data
+			} else if !bytes.Equal(old, data) {
+				d.Modified = append(d.Modified, ref)
+				d.data[ref] = data
+			}
+		}
+		for name := range before {
+			if _, kept := after[name]; !kept {
+				d.Removed = append(d.Removed, ComponentRef{entity, name})
+			}
+		}
+	}
+	for entity, after := range to.entities {
+		if _, exists := from.entities[entity]; exists {
+			continue
+		}
+		d.Spawned = append(d.Spawned, entity)
+		for name, data := range after {
+			ref := ComponentRef{entity, name}
+			d.Added = append(d.Added, ref)
+			d.data[ref] = data
+		}
+	}
+
+	sortEntities(d.Spawned)
+	sortEntities(d.Despawned)
+	sortRefs(d.Added)
+	sortRefs(d.Modified)
+	sortRefs(d.Removed)
+	return d
+}
+
+func sortEntities(entities []Entity) {
+	sort.Slice(entities, func(i, j int) bool { return entities[i] < entities[j] })
+}
+
+func sortRefs(refs []ComponentRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Entity != refs[j].Entity {
+			return refs[i].Entity < refs[j].Entity
+		}
+		return refs[i].Type < refs[j].Type
+	})
+}
+
+// Empty reports whether the delta changes no entity or component. The
+// allocator state may still differ, for example when an entity was created
+// and destroyed between the two snapshots.
+func (d *Delta) Empty() bool {
+	return len(d.Spawned) == 0 && len(d.Despawned) == 0 &&
+		len(d.Added) == 0 && len(d.Modified) == 0 && len(d.Removed) == 0
+}
+
+// The delta encoding reuses the snapshot primitives:
+//
+//	header     magic "STRD", uint16 format version
+//	ticks      uint64 base tick, uint64 tick
+//	allocator  as in snapshots
+//	type table as in snapshots, for the types the delta mentions
+//	spawned    count, then handles as varint gaps from the previous one
+//	despawned  likewise
+//	added      count, then per component: handle gap, type index and the
+//	           length-prefixed component encoding
+//	modified   likewise
+//	removed    count, then per component: handle gap and type index
+const (
+	deltaMagic   = "STRD"
+	deltaVersion = 1
+)
+
+// Encode writes the delta to out.
+func (d *Delta) Encode(out io.Writer) error {
+	var names []string
+	for _, refs := range [][]ComponentRef{d.Added, d.Modified, d.Removed} {
+		for _, ref := range refs {
+			names = append(names, ref.Type)
+		}
+	}
+	sort.Strings(names)
+	var types []*typeDescriptor
+	typeIndex := make(map[string]int)
+	for _, name := range names {
+		if _, seen := typeIndex[name]; !seen {
+			typeIndex[name] = len(types)
+			types = append(types, d.types[name])
+		}
+	}
+
+	buffered := bufio.NewWriter(out)
+	sw := &snapshotWriter{w: buffered}
+	sw.w.WriteString(deltaMagic)
+	sw.uint16(deltaVersion)
+	sw.uint64(d.BaseTick)
+	sw.uint64(d.Tick)
+	sw.allocator(d.allocator)
+	sw.typeTable(types)
+
+	for _, entities := range [][]Entity{d.Spawned, d.Despawned} {
+		sw.uvarint(uint64(len(entities)))
+		previous := Entity(0)
+		for _, entity := range entities {
+			sw.uvarint(uint64(entity - previous))
+			previous = entity
+		}
+	}
+	for _, refs := range [][]ComponentRef{d.Added, d.Modified, d.Removed} {
+		sw.uvarint(uint64(len(refs)))
+		previous := Entity(0)
+		for _, ref := range refs {
+			sw.uvarint(uint64(ref.Entity - previous))
+			previous = ref.Entity
+			sw.uvarint(uint64(typeIndex[ref.Type]))
+			if data, exists := d.data[ref]; exists {
+				sw.bytes(data)
+			}
+		}
+	}
+	return buffered.Flush()
+}
+
+// DecodeDelta reads a delta written by Encode. Component values are decoded,
+// and migrated if needed, when the delta is applied.
+func DecodeDelta(in io.Reader) (*Delta, error) {
+	sr := &snapshotReader{r: bufio.NewReader(in)}
+
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(sr.r, magic); err != nil || string(magic) != deltaMagic {
+		return nil, errors.New("not a delta")
+	}
+	if format := sr.uint16(); sr.err == nil && format != deltaVersion {
+		return nil, fmt.Errorf("unsupported delta version %d", format)
+	}
+
+	d := &Delta{
+		BaseTick: sr.uint64(),
+		Tick:     sr.uint64(),
+		types:    make(map[string]*typeDescriptor),
+		data:     make(map[ComponentRef][]byte),
+	}
+	d.allocator = sr.allocator()
+	types, err := sr.typeTable(snapshotVersion)
+	if err != nil {
+		return nil, err
+	}
+	for _, descriptor := range types {
+		d.types[descriptor.name] = descriptor
+	}
+
+	readEntities := func() []Entity {
+		entities := make([]Entity, sr.count(IndexMask+1))
+		previous := Entity(0)
+		for i := range entities {
+			previous += Entity(sr.uvarint())
+			entities[i] = previous
+		}
+		return entities
+	}
+	readRefs := func(withData bool) ([]ComponentRef, error) {
+		refs := make([]ComponentRef, sr.count(IndexMask+1))
+		previous := Entity(0)
+		for i := 0; i < len(refs) && sr.err == nil; i++ {
+			previous += Entity(sr.uvarint())
+			index := int(sr.uvarint())
+			if index >= len(types) {
+				return nil, fmt.Errorf("delta names component type %d of %d", index, len(types))
+			}
+			refs[i] = ComponentRef{previous, types[index].name}
+			if withData {
+				d.data[refs[i]] = sr.bytes()
+			}
+		}
+		return refs, nil
+	}
+
+	d.Spawned = readEntities()
+	d.Despawned = readEntities()
+	if d.Added, err = readRefs(true); err != nil {
+		return nil, err
+	}
+	if d.Modified, err = readRefs(true); err != nil {
+		return nil, err
+	}
+	if d.Removed, err = readRefs(false); err != nil {
+		return nil, err
+	}
+	if sr.err != nil {
+		return nil, fmt.Errorf("reading delta: %w", sr.err)
+	}
+	return d, nil
+}
+
+// ApplyDelta brings the world from the delta's base state to its target
+// state. The delta is decoded and checked against the world's live entities
+// before anything changes, so a delta that does not fit the world is
+// rejected with the world untouched. The whole delta is applied under the
+// storage write lock, so readers never see it half applied. Afterwards
+// "EntityDestroyed" is published for each despawned entity. Added and
+// modified components are stamped like AddComponent, so change detection
+// sees them.
+func (w *World) ApplyDelta(d *Delta) error {
+	decoded := make(map[ComponentRef]Component, len(d.data))
+	for _, refs := range [][]ComponentRef{d.Added, d.Modified} {
+		for _, ref := range refs {
+			descriptor := d.types[ref.Type]
+			if descriptor == nil {
+				return fmt.Errorf("delta has no layout for component %s", ref.Type)
+			}
+			sr := &snapshotReader{r: bytes.NewReader(d.data[ref])}
+			component, err := sr.component(descriptor)
+			if err != nil {
+				return fmt.Errorf("entity %d: component %s: %w", ref.Entity, ref.Type, err)
+			}
+			decoded[ref] = component
+		}
+	}
+	removed := make([]reflect.Type, len(d.Removed))
+	for i, ref := range d.Removed {
+		component, registered := components.New(ref.Type)
+		if !registered {
+			return fmt.Errorf("entity %d: unknown component type %q", ref.Entity, ref.Type)
+		}
+		removed[i] = reflect.TypeOf(component)
+	}
+	if err := d.allocator.validate(); err != nil {
+		return err
+	}
+	target := newEntityAllocator()
+	target.restore(d.allocator)
+
+	em := w.entityManager
+	em.lock()
+	if err := d.fits(em, target); err != nil {
+		em.mu.Unlock()
+		return err
+	}
+
+	for _, entity := range d.Despawned {
+		em.destroy(entity)
+	}
+	em.entities.restore(d.allocator)
+	for _, entity := range d.Spawned {
+		em.place(entity)
+		em.journal.logEntity(recordCreate, entity)
+	}
+	em.tick.Store(d.Tick)
+	for i, ref := range d.Removed {
+		em.remove(em.record(ref.Entity), removed[i])
+	}
+	for _, refs := range [][]ComponentRef{d.Added, d.Modified} {
+		for _, ref := range refs {
+			component := decoded[ref]
+			em.insert(em.record(ref.Entity), reflect.TypeOf(component), component)
+			em.journal.logComponent(recordAdd, ref.Entity, component)
+		}
+	}
+	em.mu.Unlock()
+
+	w.publishDestroyed(d.Despawned)
+	return nil
+}
+
+// fits checks that the delta's base state matches the manager's entities:
+// despawned entities are alive and die, spawned ones are free and come
+// alive, every other live entity stays alive, and every component change
+// names an entity that is alive afterwards. target holds the delta's
+// allocator. Callers must hold the lock.
+func (d *Delta) fits(em *EntityManager, target *entityAllocator) error {
+	despawned := make(map[Entity]bool, len(d.Despawned))
+	for _, entity := range d.Despawned {
+		if !em.entities.isAlive(entity) || target.isAlive(entity) {
+			return fmt.Errorf("delta despawns entity %d, which does not fit the world", entity)
+		}
+		despawned[entity] = true
+	}
+	spawned := make(map[Entity]bool, len(d.Spawned))
+	for _, entity := range d.Spawned {
+		if em.entities.isAlive(entity) || !target.isAlive(entity) {
+			return fmt.Errorf("delta spawns entity %d, which does not fit the world", entity)
+		}
+		spawned[entity] = true
+	}
+	for _, entity := range em.entities.aliveEntities() {
+		if !despawned[entity] && !target.isAlive(entity) {
+			return fmt.Errorf("entity %d is alive but neither kept nor despawned by the delta", entity)
+		}
+	}
+	for _, entity := range target.aliveEntities() {
+		if !spawned[entity] && (!em.entities.isAlive(entity) || despawned[entity]) {
+			return fmt.Errorf("delta keeps entity %d, which is not alive", entity)
+		}
+	}
+	for _, refs := range [][]ComponentRef{d.Added, d.Modified, d.Removed} {
+		for _, ref := range refs {
+			if !target.isAlive(ref.Entity) || (!spawned[ref.Entity] && em.record(ref.Entity) == nil) {
+				return fmt.Errorf("delta changes component %s of entity %d, which is not alive", ref.Type, ref.Entity)
+			}
+		}
+	}
+	return nil
+}
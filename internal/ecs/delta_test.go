@@ -0,0 +1,119 @@
+package ecs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+// replica loads a copy of w through SaveState.
+func replica(t *testing.T, w *World) *World {
+	t.Helper()
+	data, err := w.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	copy := NewWorld()
+	copy.InsertResource(testConfig{})
+	if err := copy.LoadState(data); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	return copy
+}
+
+func snapshot(t *testing.T, w *World) *Snapshot {
+	t.Helper()
+	s, err := w.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	return s
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	w, entities := populatedWorld(t)
+	follower := replica(t, w)
+	base := snapshot(t, w)
+
+	w.DestroyEntity(entities[3])                                 // despawned with its Mouth
+	spawned := w.CreateEntity()                                  // reuses slot 4
+	w.AddComponent(spawned, &components.Lung{Capacity: 5})       // spawned with a component
+	w.AddComponent(entities[2], &components.Mouth{IsOpen: true}) // added
+	w.RemoveComponent(entities[0], TypeOf[*components.Mouth]())  // removed
+	w.AddComponent(entities[6], &components.Lung{Volume: 0.5})   // added
+	lung, _ := Get[*components.Lung](w, entities[0])             // modified
+	lung.Volume = 0.75
+
+	d := Diff(base, snapshot(t, w))
+	if len(d.Spawned) != 1 || len(d.Despawned) != 1 || len(d.Added) != 3 || len(d.Modified) != 1 || len(d.Removed) != 1 {
+		t.Fatalf("delta = %d spawned, %d despawned, %d added, %d modified, %d removed",
+			len(d.Spawned), len(d.Despawned), len(d.Added), len(d.Modified), len(d.Removed))
+	}
+
+	var buf bytes.Buffer
+	if err := d.Encode(&buf); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := DecodeDelta(&buf)
+	if err != nil {
+		t.Fatalf("DecodeDelta: %v", err)
+	}
+	if err := follower.ApplyDelta(decoded); err != nil {
+		t.Fatalf("ApplyDelta: %v", err)
+	}
+
+	want, _ := w.SaveState()
+	got, _ := follower.SaveState()
+	if !bytes.Equal(want, got) {
+		t.Errorf("follower differs after applying the delta:\n%s\n%s", want, got)
+	}
+	if !Diff(snapshot(t, w), snapshot(t, follower)).Empty() {
+		t.Errorf("snapshots differ after applying the delta")
+	}
+}
+
+func TestApplyDeltaRejectsMismatchedWorld(t *testing.T) {
+	w, entities := populatedWorld(t)
+	base := snapshot(t, w)
+	w.DestroyEntity(entities[0])
+	d := Diff(base, snapshot(t, w))
+
+	// This world has entities the delta knows nothing about.
+	other := NewWorld()
+	var entity Entity
+	for i := 0; i < 8; i++ {
+		entity = other.CreateEntity()
+	}
+	other.AddComponent(entity, &components.Mouth{IsOpen: true})
+	if err := other.ApplyDelta(d); err == nil {
+		t.Fatal("ApplyDelta accepted a delta for another world")
+	}
+	if mouth, ok := Get[*components.Mouth](other, entity); !ok || !mouth.IsOpen {
+		t.Errorf("world changed by a rejected delta")
+	}
+}
+
+func TestApplyDeltaRejectsChangesToDeadEntities(t *testing.T) {
+	w, entities := populatedWorld(t)
+	r := replica(t, w)
+	w.DestroyEntity(entities[0])
+	w.AddComponent(entities[3], &components.Lung{Capacity: 5})
+	d := Diff(snapshot(t, r), snapshot(t, w))
+	if len(d.Added) != 1 {
+		t.Fatalf("delta adds %d components, want 1", len(d.Added))
+	}
+
+	// Point the added component at the entity the delta despawns.
+	dead := ComponentRef{Entity: entities[0], Type: d.Added[0].Type}
+	d.data[dead] = d.data[d.Added[0]]
+	d.Added[0] = dead
+
+	before := snapshot(t, r)
+	if err := r.ApplyDelta(d); err == nil {
+		t.Fatal("ApplyDelta accepted a component for a dead entity")
+	}
+	if !Diff(before, snapshot(t, r)).Empty() || !r.IsAlive(entities[0]) {
+		t.Errorf("world changed by a rejected delta")
+	}
+}
@@ -1,6 +1,13 @@
 // File: internal/ecs/entity.go
 package ecs
 
+import (
+    "fmt"
+    "sync"
+)
+
+// Entity is a generational handle: the low IndexBits address a slot and the
+// high VersionBits count how many times that slot has been recycled.
 type Entity uint32
 
 const (
@@ -8,4 +15,170 @@ const (
     VersionBits = 8
     IndexMask   = (1 << IndexBits) - 1
     VersionMask = (1 << VersionBits) - 1
-)
\ No newline at end of file
+)
+
+// NewEntity packs an index and version into an Entity handle.
+func NewEntity(index, version uint32) Entity {
+    return Entity((version&VersionMask)<<IndexBits | index&IndexMask)
+}
+
+// Index returns the slot the entity occupies.
+func (e Entity) Index() uint32 {
+    return uint32(e) & IndexMask
+}
+
+// Version returns the generation of the entity's slot.
+func (e Entity) Version() uint32 {
+    return uint32(e) >> IndexBits & VersionMask
+}
+
+// entityAllocator hands out entity handles and recycles destroyed indices
+// with a bumped version so stale handles can be told apart from live ones.
+type entityAllocator struct {
+    versions []uint8
+    alive    []bool
+    free     []uint32
+    mu       sync.Mutex
+}
+
+func newEntityAllocator() *entityAllocator {
+    return &entityAllocator{}
+}
+
+func (a *entityAllocator) allocate() Entity {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    // Recycle the oldest free slot first so a slot's versions are spread as
+    // far apart in time as possible.
+    if len(a.free) > 0 {
+        index := a.free[0]
+        a.free = a.free[1:]
+        a.alive[index] = true
+        return NewEntity(index, uint32(a.versions[index]))
+    }
+
+    index := uint32(len(a.versions))
+    if index > IndexMask {
+        panic(fmt.Sprintf("entity index space exhausted (%d entities)", index))
+    }
+    a.versions = append(a.versions, 0)
+    a.alive = append(a.alive, true)
+    return NewEntity(index, 0)
+}
+
+// release frees the entity's slot. It reports false for stale or unknown
+// handles, which are left untouched.
+func (a *entityAllocator) release(entity Entity) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    if !a.isAliveLocked(entity) {
+        return false
+    }
+    index := entity.Index()
+    a.alive[index] = false
+    a.versions[index]++ // wraps at VersionMask
+    a.free = append(a.free, index)
+    return true
+}
+
+func (a *entityAllocator) isAlive(entity Entity) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return a.isAliveLocked(entity)
+}
+
+func (a *entityAllocator) isAliveLocked(entity Entity) bool {
+    index := entity.Index()
+    return int(index) < len(a.alive) && a.alive[index] && uint32(a.versions[index]) == entity.Version()
+}
+
+// aliveEntities returns every live handle in index order.
+func (a *entityAllocator) aliveEntities() []Entity {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    result := make([]Entity, 0, len(a.alive)-len(a.free))
+    for index, alive := range a.alive {
+        if alive {
+            result = append(result, NewEntity(uint32(index), uint32(a.versions[index])))
+        }
+    }
+    return result
+}
+
+// claim makes the exact handle alive, as if it had just been allocated. It
+// is used to replay a journal and reports false if the slot is taken.
+func (a *entityAllocator) claim(entity Entity) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    index := entity.Index()
+    for int(index) >= len(a.versions) {
+        a.free = append(a.free, uint32(len(a.versions)))
+        a.versions = append(a.versions, 0)
+        a.alive = append(a.alive, false)
+    }
+    if a.alive[index] {
+        return false
+    }
+    for i, free := range a.free {
+        if free == index {
+            a.free = append(a.free[:i], a.free[i+1:]...)
+            break
+        }
+    }
+    a.versions[index] = uint8(entity.Version())
+    a.alive[index] = true
+    return true
+}
+
+// allocatorState is the persistent part of an allocator: the version of
+// every slot and the free list in recycling order. Slots not on the free list
+// are alive.
+type allocatorState struct {
+    Versions []uint8
+    Free     []uint32
+}
+
+func (a *entityAllocator) state() allocatorState {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    return allocatorState{
+        Versions: append([]uint8(nil), a.versions...),
+        Free:     append([]uint32(nil), a.free...),
+    }
+}
+
+// validate checks that the free list only names existing slots, once each.
+func (s allocatorState) validate() error {
+    if len(s.Versions) > IndexMask+1 {
+        return fmt.Errorf("allocator has %d slots, more than the index space", len(s.Versions))
+    }
+    seen := make([]bool, len(s.Versions))
+    for _, index := range s.Free {
+        if int(index) >= len(s.Versions) || seen[index] {
+            return fmt.Errorf("invalid free slot %d", index)
+        }
+        seen[index] = true
+    }
+    return nil
+}
+
+// restore replaces the allocator's state. The state must be valid.
+func (a *entityAllocator) restore(state allocatorState) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    a.versions = append([]uint8(nil), state.Versions...)
+    a.free = append([]uint32(nil), state.Free...)
+    a.alive = make([]bool, len(a.versions))
+    for index := range a.alive {
+        a.alive[index] = true
+    }
+    for _, index := range a.free {
+        a.alive[index] = false
+    }
+}
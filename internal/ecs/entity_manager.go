@@ -4,188 +4,382 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
-    "github.com/AMMPTT/strux/pkg/components"
-	
+	"sync/atomic"
 )
 
+// entityRecord locates an entity's row. A record is only valid while entity
+// matches the handle being looked up.
+type entityRecord struct {
+	entity    Entity
+	archetype *Archetype
+	row       int
+}
+
 type EntityManager struct {
-    entities       map[Entity]bool
-    components     map[Entity]map[reflect.Type]components.ComponentData
-    archetypes     []*Archetype
-    componentPools map[reflect.Type]*ComponentPool
-    nextEntityID   Entity
-    mu             sync.RWMutex
+	entities         *entityAllocator
+	records          []entityRecord // indexed by Entity.Index()
+	archetypes       []*Archetype
+	archetypesByHash map[uint64][]*Archetype
+	componentIDs     map[reflect.Type]ComponentID
+	componentTypes   []reflect.Type // indexed by ComponentID
+	componentPools   map[reflect.Type]*ComponentPool
+	queries          []*Query
+	removed          map[reflect.Type]*removalLog
+	tick             atomic.Uint64 // world tick, advanced once per World.Update
+	changeTick       atomic.Uint64 // stamped on component writes
+	accessCheck      atomic.Pointer[accessCheckFunc]
+	journal          *Journal     // set and cleared under the write lock
+	parallel         atomic.Int32 // non-exclusive systems currently running
+	mu               sync.RWMutex
+}
+
+// accessCheckFunc is installed by the scheduler in debug mode and called for
+// every component read and write.
+type accessCheckFunc func(componentType reflect.Type, write bool)
+
+func (em *EntityManager) setAccessCheck(check accessCheckFunc) {
+	if check == nil {
+		em.accessCheck.Store(nil)
+		return
+	}
+	em.accessCheck.Store(&check)
+}
+
+func (em *EntityManager) checkAccess(componentType reflect.Type, write bool) {
+	if check := em.accessCheck.Load(); check != nil {
+		(*check)(componentType, write)
+	}
+}
+
+// lock takes the write lock for a structural change. Systems running in
+// parallel may not make one: another system iterating a query holds the read
+// lock, and a waiting writer would keep it from taking the read lock again,
+// deadlocking both. Such systems use Commands or declare Access.Structural.
+func (em *EntityManager) lock() {
+	if em.parallel.Load() > 0 {
+		panic("ecs: structural change while systems run in parallel; use Commands or declare Access.Structural")
+	}
+	em.mu.Lock()
 }
 
 func NewEntityManager() *EntityManager {
-	return &EntityManager{
-		entities:       make(map[Entity]bool),
-		components:     make(map[Entity]map[reflect.Type]Component),
-		archetypes:     make([]*Archetype, 0),
-		componentPools: make(map[reflect.Type]*ComponentPool),
+	em := &EntityManager{
+		entities:         newEntityAllocator(),
+		archetypes:       make([]*Archetype, 0),
+		archetypesByHash: make(map[uint64][]*Archetype),
+		componentIDs:     make(map[reflect.Type]ComponentID),
+		componentPools:   make(map[reflect.Type]*ComponentPool),
+		removed:          make(map[reflect.Type]*removalLog),
 	}
+	// Queries start with lastRun 0, so the first stamp must be newer.
+	em.changeTick.Store(1)
+	em.archetypeFor(nil)
+	return em
 }
 
 func (em *EntityManager) CreateEntity() Entity {
-	em.mu.Lock()
+	em.lock()
 	defer em.mu.Unlock()
-	
-	id := em.nextEntityID
-	em.entities[id] = true
-	em.components[id] = make(map[reflect.Type]Component)
-	em.nextEntityID++
+
+	id := em.entities.allocate()
+	em.place(id)
+	em.journal.logEntity(recordCreate, id)
 	return id
 }
 
-func (em *EntityManager) DestroyEntity(entity Entity) {
-	em.mu.Lock()
+// place puts a freshly allocated entity into the empty archetype.
+func (em *EntityManager) place(entity Entity) {
+	root := em.archetypes[0]
+	index := int(entity.Index())
+	for len(em.records) <= index {
+		em.records = append(em.records, entityRecord{})
+	}
+	em.records[index] = entityRecord{entity: entity, archetype: root, row: len(root.entities)}
+	root.entities = append(root.entities, entity)
+}
+
+// IsAlive reports whether the handle refers to a live entity. Handles kept
+// after the entity was destroyed report false even once the index is reused.
+func (em *EntityManager) IsAlive(entity Entity) bool {
+	return em.entities.isAlive(entity)
+}
+
+// record returns the entity's storage record, or nil for dead handles.
+func (em *EntityManager) record(entity Entity) *entityRecord {
+	index := int(entity.Index())
+	if index >= len(em.records) {
+		return nil
+	}
+	record := &em.records[index]
+	if record.entity != entity || record.archetype == nil {
+		return nil
+	}
+	return record
+}
+
+// DestroyEntity removes the entity and returns its pooled components. It
+// reports whether the entity was alive.
+func (em *EntityManager) DestroyEntity(entity Entity) bool {
+	em.lock()
 	defer em.mu.Unlock()
-	
-	if !em.entities[entity] {
-		return // Entity doesn't exist, nothing to do
+	return em.destroy(entity)
+}
+
+// destroy is DestroyEntity for callers holding the write lock.
+func (em *EntityManager) destroy(entity Entity) bool {
+	record := em.record(entity)
+	if !em.entities.release(entity) {
+		return false // Entity doesn't exist, nothing to do
 	}
-	
-	delete(em.entities, entity)
-	for componentType, component := range em.components[entity] {
-		if pool, exists := em.componentPools[componentType]; exists {
-			pool.Return(component)
-		}
+	em.journal.logEntity(recordDestroy, entity)
+	if record == nil {
+		return true // Reserved but never spawned
 	}
-	delete(em.components, entity)
-	
-	for _, archetype := range em.archetypes {
-		for i, e := range archetype.entities {
-			if e == entity {
-				lastIdx := len(archetype.entities) - 1
-				archetype.entities[i] = archetype.entities[lastIdx]
-				archetype.entities = archetype.entities[:lastIdx]
-				for j := range archetype.components {
-					archetype.components[j][i] = archetype.components[j][lastIdx]
-					archetype.components[j] = archetype.components[j][:lastIdx]
-				}
-				break
-			}
+
+	archetype := record.archetype
+	for i, componentType := range archetype.componentTypes {
+		em.checkAccess(componentType, true)
+		if pool, exists := em.componentPools[componentType]; exists {
+			pool.Return(archetype.components[i][record.row])
 		}
+		em.recordRemoval(componentType, entity)
 	}
+	em.removeRow(archetype, record.row)
+	*record = entityRecord{}
+	return true
 }
 
+// UpdateComponent replaces the entity's component of the same type. It only
+// takes the read lock, like an in-place write through a pointer, so systems
+// that declare the write may call it while others run.
 func (em *EntityManager) UpdateComponent(entity Entity, component Component) {
-    em.mu.Lock()
-    defer em.mu.Unlock()
-
-    if !em.entities[entity] {
-        panic(fmt.Sprintf("Entity %d does not exist", entity))
-    }
-
-    componentType := reflect.TypeOf(component)
-    if _, exists := em.components[entity][componentType]; !exists {
-        panic(fmt.Sprintf("Component of type %v does not exist for entity %d", componentType, entity))
-    }
-
-    em.components[entity][componentType] = component
-
-    // Update component in the appropriate archetype
-    for _, archetype := range em.archetypes {
-        for i, e := range archetype.entities {
-            if e == entity {
-                for j, ct := range archetype.componentTypes {
-                    if ct == componentType {
-                        archetype.components[j][i] = component
-                        break
-                    }
-                }
-                break
-            }
-        }
-    }
-
-    fmt.Printf("Updated Component!... %d %+v\n", entity, component)
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	record := em.record(entity)
+	if record == nil {
+		panic(fmt.Sprintf("Entity %d does not exist", entity))
+	}
+
+	componentType := reflect.TypeOf(component)
+	em.checkAccess(componentType, true)
+	column := record.archetype.column(componentType)
+	if column < 0 {
+		panic(fmt.Sprintf("Component of type %v does not exist for entity %d", componentType, entity))
+	}
+
+	record.archetype.components[column][record.row] = component
+	record.archetype.ticks[column][record.row].changed = em.changeTick.Load()
+	em.journal.logComponent(recordUpdate, entity, component)
 }
 
 func (em *EntityManager) AddComponent(entity Entity, component Component) {
-	em.mu.Lock()
+	em.addComponent(entity, reflect.TypeOf(component), component)
+}
+
+// addComponent is AddComponent with the component's type already known, so
+// generic callers can skip reflect.TypeOf.
+func (em *EntityManager) addComponent(entity Entity, componentType reflect.Type, component Component) {
+	em.lock()
 	defer em.mu.Unlock()
-	fmt.Println("Adding Component!...", entity, component)
 
-	
-	if !em.entities[entity] {
+	record := em.storedRecord(entity)
+	if record == nil {
 		panic(fmt.Sprintf("Entity %d does not exist", entity))
 	}
-	
-	componentType := reflect.TypeOf(component)
+	em.insert(record, componentType, component)
+	em.journal.logComponent(recordAdd, entity, component)
+}
+
+// GetOrAddComponent returns the entity's component of the given type,
+// attaching a new one from NewComponent first if it is missing.
+func (em *EntityManager) GetOrAddComponent(entity Entity, componentType reflect.Type) Component {
+	if component, ok := em.getForWrite(entity, componentType); ok {
+		return component
+	}
+
+	em.lock()
+	defer em.mu.Unlock()
+
+	record := em.storedRecord(entity)
+	if record == nil {
+		panic(fmt.Sprintf("Entity %d does not exist", entity))
+	}
+	if column := record.archetype.column(componentType); column >= 0 {
+		em.checkAccess(componentType, true)
+		return record.archetype.components[column][record.row]
+	}
+
+	var component Component
 	if pool, exists := em.componentPools[componentType]; exists {
 		component = pool.Get()
+	} else {
+		component = newComponent(componentType)
 	}
-	em.components[entity][componentType] = component
-	
-	// Update archetypes
-	componentTypes := make([]reflect.Type, 0, len(em.components[entity]))
-	for ct := range em.components[entity] {
-		componentTypes = append(componentTypes, ct)
-	}
-	
-	var targetArchetype *Archetype
-	for _, archetype := range em.archetypes {
-		if reflect.DeepEqual(archetype.componentTypes, componentTypes) {
-			targetArchetype = archetype
-			break
+	em.insert(record, componentType, component)
+	// The caller fills the component in; journal its value at tick end.
+	em.journal.logComponent(recordAdd, entity, component)
+	em.journal.markDirty(entity, componentType)
+	return component
+}
+
+// getForWrite returns the entity's component of the given type under the
+// read lock, checking it as a write.
+func (em *EntityManager) getForWrite(entity Entity, componentType reflect.Type) (Component, bool) {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	if record := em.record(entity); record != nil {
+		if column := record.archetype.column(componentType); column >= 0 {
+			em.checkAccess(componentType, true)
+			return record.archetype.components[column][record.row], true
 		}
 	}
-	
-	if targetArchetype == nil {
-		targetArchetype = &Archetype{
-			componentTypes: componentTypes,
-			entities:       make([]Entity, 0),
-			components:     make([][]Component, len(componentTypes)),
-		}
-		em.archetypes = append(em.archetypes, targetArchetype)
+	return nil, false
+}
+
+// insert stores the component on the entity, replacing it in place when the
+// entity already has that type and otherwise moving the entity along the
+// archetype graph's add edge. Replacing counts as a change, attaching as an
+// addition.
+func (em *EntityManager) insert(record *entityRecord, componentType reflect.Type, component Component) {
+	em.checkAccess(componentType, true)
+	tick := em.changeTick.Load()
+	if column := record.archetype.column(componentType); column >= 0 {
+		record.archetype.components[column][record.row] = component
+		record.archetype.ticks[column][record.row].changed = tick
+		return
 	}
-	
-	targetArchetype.entities = append(targetArchetype.entities, entity)
-	for i, ct := range targetArchetype.componentTypes {
-		targetArchetype.components[i] = append(targetArchetype.components[i], em.components[entity][ct])
+
+	id := em.componentID(componentType)
+	source := record.archetype
+	target, exists := source.addEdges[id]
+	if !exists {
+		target = em.archetypeFor(withComponentID(source.componentIDs, id))
+		source.addEdges[id] = target
+		target.removeEdges[id] = source
 	}
+
+	em.moveEntity(record, target)
+	column := target.column(componentType)
+	target.components[column][record.row] = component
+	target.ticks[column][record.row] = componentTicks{added: tick, changed: tick}
 }
 
 func (em *EntityManager) RemoveComponent(entity Entity, componentType reflect.Type) {
-	em.mu.Lock()
+	em.lock()
 	defer em.mu.Unlock()
-	
-	if !em.entities[entity] {
+
+	record := em.record(entity)
+	if record == nil {
 		return // Entity doesn't exist, nothing to do
 	}
-	
-	if component, exists := em.components[entity][componentType]; exists {
-		if pool, exists := em.componentPools[componentType]; exists {
-			pool.Return(component)
+	em.remove(record, componentType)
+}
+
+// remove detaches a component from the entity of the record, if it has one.
+// Callers must hold the write lock.
+func (em *EntityManager) remove(record *entityRecord, componentType reflect.Type) {
+	entity := record.entity
+	em.checkAccess(componentType, true)
+	column := record.archetype.column(componentType)
+	if column < 0 {
+		return
+	}
+	if pool, exists := em.componentPools[componentType]; exists {
+		pool.Return(record.archetype.components[column][record.row])
+	}
+
+	id := em.componentIDs[componentType]
+	source := record.archetype
+	target, exists := source.removeEdges[id]
+	if !exists {
+		target = em.archetypeFor(withoutComponentID(source.componentIDs, id))
+		source.removeEdges[id] = target
+		target.addEdges[id] = source
+	}
+	em.moveEntity(record, target)
+	em.recordRemoval(componentType, entity)
+	em.journal.logRemove(entity, componentType)
+}
+
+// moveEntity appends the entity's row to target, carrying over every
+// component both archetypes share, and swap-removes it from its current
+// archetype. Columns only present in target are left nil for the caller.
+func (em *EntityManager) moveEntity(record *entityRecord, target *Archetype) {
+	source, row := record.archetype, record.row
+
+	newRow := len(target.entities)
+	target.entities = append(target.entities, record.entity)
+	for i, componentType := range target.componentTypes {
+		var component Component
+		var ticks componentTicks
+		if column := source.column(componentType); column >= 0 {
+			component = source.components[column][row]
+			ticks = source.ticks[column][row]
 		}
-		delete(em.components[entity], componentType)
-		
-		// Update archetypes
-		for _, archetype := range em.archetypes {
-			for i, e := range archetype.entities {
-				if e == entity {
-					lastIdx := len(archetype.entities) - 1
-					archetype.entities[i] = archetype.entities[lastIdx]
-					archetype.entities = archetype.entities[:lastIdx]
-					for j := range archetype.components {
-						archetype.components[j][i] = archetype.components[j][lastIdx]
-						archetype.components[j] = archetype.components[j][:lastIdx]
-					}
-					break
-				}
-			}
+		target.components[i] = append(target.components[i], component)
+		target.ticks[i] = append(target.ticks[i], ticks)
+	}
+
+	em.removeRow(source, row)
+	record.archetype = target
+	record.row = newRow
+}
+
+// removeRow swap-removes a row and fixes the record of the entity that was
+// moved into it.
+func (em *EntityManager) removeRow(archetype *Archetype, row int) {
+	if moved, ok := archetype.swapRemove(row); ok {
+		em.records[moved.Index()].row = row
+	}
+}
+
+// componentID returns the ID of a component type, registering it on first
+// use. Callers must hold the write lock.
+func (em *EntityManager) componentID(componentType reflect.Type) ComponentID {
+	if id, exists := em.componentIDs[componentType]; exists {
+		return id
+	}
+	id := ComponentID(len(em.componentTypes))
+	em.componentIDs[componentType] = id
+	em.componentTypes = append(em.componentTypes, componentType)
+	return id
+}
+
+// archetypeFor returns the archetype for a sorted component ID set, creating
+// it if needed. Callers must hold the write lock.
+func (em *EntityManager) archetypeFor(componentIDs []ComponentID) *Archetype {
+	hash := archetypeHash(componentIDs)
+	for _, archetype := range em.archetypesByHash[hash] {
+		if sameComponentIDs(archetype.componentIDs, componentIDs) {
+			return archetype
 		}
 	}
+
+	componentTypes := make([]reflect.Type, len(componentIDs))
+	for i, id := range componentIDs {
+		componentTypes[i] = em.componentTypes[id]
+	}
+
+	archetype := newArchetype(ArchetypeID(len(em.archetypes)), componentIDs, componentTypes)
+	em.archetypes = append(em.archetypes, archetype)
+	em.archetypesByHash[hash] = append(em.archetypesByHash[hash], archetype)
+	for _, q := range em.queries {
+		q.consider(archetype)
+	}
+	return archetype
 }
 
 func (em *EntityManager) GetComponent(entity Entity, componentType reflect.Type) (Component, bool) {
 	em.mu.RLock()
 	defer em.mu.RUnlock()
-	
-	if components, exists := em.components[entity]; exists {
-		component, exists := components[componentType]
-		return component, exists
+
+	em.checkAccess(componentType, false)
+	if record := em.record(entity); record != nil {
+		if column := record.archetype.column(componentType); column >= 0 {
+			return record.archetype.components[column][record.row], true
+		}
 	}
 	return nil, false
 }
@@ -193,29 +387,10 @@ func (em *EntityManager) GetComponent(entity Entity, componentType reflect.Type)
 func (em *EntityManager) Query(componentTypes ...reflect.Type) []Entity {
 	em.mu.RLock()
 	defer em.mu.RUnlock()
-	
+
 	var result []Entity
 	for _, archetype := range em.archetypes {
-		if len(archetype.componentTypes) < len(componentTypes) {
-			continue
-		}
-		
-		match := true
-		for _, queryType := range componentTypes {
-			found := false
-			for _, archetypeType := range archetype.componentTypes {
-				if archetypeType == queryType {
-					found = true
-					break
-				}
-			}
-			if !found {
-				match = false
-				break
-			}
-		}
-		
-		if match {
+		if archetype.hasAll(componentTypes) {
 			result = append(result, archetype.entities...)
 		}
 	}
@@ -223,10 +398,40 @@ func (em *EntityManager) Query(componentTypes ...reflect.Type) []Entity {
 }
 
 func (em *EntityManager) InitializeComponentPool(componentType reflect.Type) {
-	em.mu.Lock()
+	em.lock()
 	defer em.mu.Unlock()
-	
+
 	if _, exists := em.componentPools[componentType]; !exists {
 		em.componentPools[componentType] = NewComponentPool(componentType)
 	}
-}
\ No newline at end of file
+}
+
+// NewComponent returns a zeroed component of the given type, reusing a
+// pooled instance when one is available.
+func (em *EntityManager) NewComponent(componentType reflect.Type) Component {
+	em.mu.RLock()
+	pool, exists := em.componentPools[componentType]
+	em.mu.RUnlock()
+
+	if exists {
+		return pool.Get()
+	}
+	return newComponent(componentType)
+}
+
+// reset drops every entity and component, restores the allocator and
+// recreates its live entities without components. Component IDs and pools
+// are kept. Callers must hold the write lock.
+func (em *EntityManager) reset(state allocatorState) {
+	em.entities.restore(state)
+	em.records = em.records[:0]
+	em.archetypes = em.archetypes[:0]
+	em.archetypesByHash = make(map[uint64][]*Archetype)
+	for _, q := range em.queries {
+		q.matched = q.matched[:0]
+	}
+	em.archetypeFor(nil)
+	for _, entity := range em.entities.aliveEntities() {
+		em.place(entity)
+	}
+}
@@ -0,0 +1,113 @@
+package ecs
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+// requireStorage checks that every entity in want is stored with exactly the
+// given components, that its record points at a row holding it, and that no
+// other rows exist.
+func requireStorage(t *testing.T, em *EntityManager, want map[Entity]map[reflect.Type]Component) {
+	t.Helper()
+	rows := 0
+	for _, archetype := range em.archetypes {
+		rows += archetype.Len()
+		for row, entity := range archetype.entities {
+			if record := em.records[entity.Index()]; record.entity != entity || record.archetype != archetype || record.row != row {
+				t.Fatalf("entity %d is in archetype %d row %d, but its record says archetype %d row %d",
+					entity, archetype.id, row, record.archetype.id, record.row)
+			}
+		}
+	}
+	if rows != len(want) {
+		t.Fatalf("%d rows stored, want %d", rows, len(want))
+	}
+
+	for entity, stored := range want {
+		record := em.record(entity)
+		if record == nil {
+			t.Fatalf("entity %d has no record", entity)
+		}
+		archetype := record.archetype
+		if archetype.entities[record.row] != entity {
+			t.Fatalf("record of entity %d points at row %d holding %d", entity, record.row, archetype.entities[record.row])
+		}
+		if len(archetype.componentTypes) != len(stored) {
+			t.Fatalf("entity %d is stored with %v, want %d components", entity, archetype.componentTypes, len(stored))
+		}
+		for componentType, component := range stored {
+			column := archetype.column(componentType)
+			if column < 0 || archetype.components[column][record.row] != component {
+				t.Fatalf("entity %d lost its %v", entity, componentType)
+			}
+		}
+	}
+}
+
+func TestComponentMovesKeepRecordsInSync(t *testing.T) {
+	em := NewEntityManager()
+	lung, mouth := TypeOf[*components.Lung](), TypeOf[*components.Mouth]()
+	want := make(map[Entity]map[reflect.Type]Component)
+	var entities []Entity
+	for i := 0; i < 12; i++ {
+		entity := em.CreateEntity()
+		entities = append(entities, entity)
+		want[entity] = make(map[reflect.Type]Component)
+	}
+
+	// Random adds, replacements, removals and destroys move rows in and out
+	// of the middle of every archetype.
+	random := rand.New(rand.NewSource(1))
+	for step := 0; step < 500; step++ {
+		i := random.Intn(len(entities))
+		entity := entities[i]
+		switch random.Intn(10) {
+		case 0:
+			em.DestroyEntity(entity)
+			delete(want, entity)
+			entities[i] = em.CreateEntity()
+			want[entities[i]] = make(map[reflect.Type]Component)
+		case 1, 2:
+			component := &components.Lung{Volume: float32(step)}
+			em.AddComponent(entity, component)
+			want[entity][lung] = component
+		case 3, 4:
+			component := &components.Mouth{IsOpen: step%2 == 0}
+			em.AddComponent(entity, component)
+			want[entity][mouth] = component
+		case 5, 6:
+			em.RemoveComponent(entity, lung)
+			delete(want[entity], lung)
+		default:
+			em.RemoveComponent(entity, mouth)
+			delete(want[entity], mouth)
+		}
+		requireStorage(t, em, want)
+	}
+}
+
+func TestRemovingFirstRowMovesLastEntity(t *testing.T) {
+	em := NewEntityManager()
+	var entities []Entity
+	for i := 0; i < 3; i++ {
+		entity := em.CreateEntity()
+		em.AddComponent(entity, &components.Lung{Capacity: float32(i)})
+		entities = append(entities, entity)
+	}
+	em.RemoveComponent(entities[0], TypeOf[*components.Lung]())
+
+	record := em.record(entities[2])
+	if record.row != 0 || record.archetype.entities[0] != entities[2] {
+		t.Fatalf("last entity at row %d, want it swapped into row 0", record.row)
+	}
+	for i, entity := range entities[1:] {
+		component, ok := em.GetComponent(entity, TypeOf[*components.Lung]())
+		if lung, _ := component.(*components.Lung); !ok || lung.Capacity != float32(i+1) {
+			t.Errorf("entity %d lung = %v, want capacity %d", entity, component, i+1)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package ecs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEntityPacking(t *testing.T) {
+	entity := NewEntity(IndexMask, VersionMask)
+	if entity.Index() != IndexMask || entity.Version() != VersionMask {
+		t.Errorf("NewEntity(%d, %d) unpacks to %d, %d", IndexMask, VersionMask, entity.Index(), entity.Version())
+	}
+	if entity := NewEntity(5, VersionMask+3); entity.Version() != 2 {
+		t.Errorf("version %d, want it wrapped to 2", entity.Version())
+	}
+}
+
+func TestAllocatorRecyclesOldestSlotFirst(t *testing.T) {
+	a := newEntityAllocator()
+	var entities []Entity
+	for i := 0; i < 4; i++ {
+		entities = append(entities, a.allocate())
+	}
+	for _, i := range []int{2, 0, 3} {
+		if !a.release(entities[i]) {
+			t.Fatalf("release(%d) = false", entities[i])
+		}
+	}
+
+	var got []Entity
+	for i := 0; i < 4; i++ {
+		got = append(got, a.allocate())
+	}
+	want := []Entity{NewEntity(2, 1), NewEntity(0, 1), NewEntity(3, 1), NewEntity(4, 0)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("allocated %v, want %v", got, want)
+	}
+}
+
+func TestStaleHandles(t *testing.T) {
+	a := newEntityAllocator()
+	stale := a.allocate()
+	a.release(stale)
+	current := a.allocate()
+	if current.Index() != stale.Index() || current.Version() != stale.Version()+1 {
+		t.Fatalf("reallocated %d, want slot %d with version %d", current, stale.Index(), stale.Version()+1)
+	}
+
+	if a.isAlive(stale) {
+		t.Error("stale handle is alive")
+	}
+	if a.isAlive(NewEntity(7, 0)) {
+		t.Error("never allocated handle is alive")
+	}
+	if a.release(stale) {
+		t.Error("release of a stale handle succeeded")
+	}
+	if !a.isAlive(current) {
+		t.Error("releasing a stale handle freed the current one")
+	}
+	if next := a.allocate(); next.Index() == current.Index() {
+		t.Error("releasing a stale handle put the slot on the free list")
+	}
+}
+
+func TestVersionWrapsAround(t *testing.T) {
+	a := newEntityAllocator()
+	first := a.allocate()
+	entity := first
+	for i := 0; i <= VersionMask; i++ {
+		a.release(entity)
+		entity = a.allocate()
+	}
+	if entity != first {
+		t.Errorf("after %d recycles got %d, want the version to wrap back to %d", VersionMask+1, entity, first)
+	}
+}
@@ -1,56 +1,403 @@
 package ecs
 
 import (
+    "errors"
     "fmt"
+    "os"
+    "reflect"
+    "runtime/debug"
     "sync"
+    "sync/atomic"
 )
 
+// EventManager delivers published events to the callbacks subscribed to
+// their type, in order of priority, highest first, and then of subscription,
+// on the publisher's goroutine. Callbacks may subscribe, unsubscribe and
+// publish themselves; a callback that panics is recovered and reported to
+// the error handler.
 type EventManager struct {
-    subscribers map[string]map[uint64]func(interface{})
-    mu          sync.RWMutex
-    nextID      uint64
+    subscribers  map[string][]*subscription // sorted in delivery order
+    byEntity     map[Entity][]*subscription // entity-scoped subscriptions
+    mu           sync.RWMutex
+    nextID       uint64
+    errorHandler func(error)
+    depth        atomic.Int32 // deliveries in progress, for maxDepth
+}
+
+// maxDepth bounds the deliveries in progress at once, so subscribers that
+// keep publishing to each other cannot overflow the stack. The count is
+// shared by all goroutines, so the limit is far above any sane nesting.
+const maxDepth = 1000
+
+// ErrEventCascade is reported when an event is published while maxDepth
+// deliveries are already in progress. The event is dropped.
+var ErrEventCascade = errors.New("event cascade limit reached; event dropped")
+
+// subscription is one callback. Subscription lists are replaced rather than
+// modified, so deliver can walk a list after releasing the lock.
+type subscription struct {
+    id        uint64
+    eventType string
+    priority  int
+    handler   func(interface{}) bool // reports whether the event was handled
+    filter    func(interface{}) bool // nil, or whether to pass the event on
+    scoped    bool                   // dropped when entity is destroyed
+    entity    Entity
+    removed   atomic.Bool
+}
+
+// SubscriberPanicError reports a callback that panicked while handling an
+// event.
+type SubscriberPanicError struct {
+    EventType string
+    ID        uint64 // subscription ID
+    Value     interface{}
+    Stack     []byte
+}
+
+func (e *SubscriberPanicError) Error() string {
+    return fmt.Sprintf("subscriber %d to %s panicked: %v", e.ID, e.EventType, e.Value)
 }
 
 func NewEventManager() *EventManager {
     return &EventManager{
-        subscribers: make(map[string]map[uint64]func(interface{})),
+        subscribers: make(map[string][]*subscription),
+        byEntity:    make(map[Entity][]*subscription),
         nextID:      1,
     }
 }
 
-func (em *EventManager) Subscribe(eventType string, callback func(interface{})) uint64 {
+// SetErrorHandler sets the function that receives a *SubscriberPanicError
+// for every recovered panic, and ErrEventCascade when callbacks publish
+// without end. By default errors are printed to standard error.
+// Passing nil restores the default.
+func (em *EventManager) SetErrorHandler(handler func(error)) {
     em.mu.Lock()
     defer em.mu.Unlock()
+    em.errorHandler = handler
+}
 
-    if em.subscribers[eventType] == nil {
-        em.subscribers[eventType] = make(map[uint64]func(interface{}))
-    }
+// Subscribe registers a callback for events of the given type and returns
+// the subscription ID. The optional priority, 0 by default, moves the
+// callback ahead of subscribers with lower priorities.
+func (em *EventManager) Subscribe(eventType string, callback func(interface{}), priority ...int) uint64 {
+    return em.SubscribeHandler(eventType, func(data interface{}) bool {
+        callback(data)
+        return false
+    }, priority...)
+}
+
+// SubscribeHandler is like Subscribe for handlers that may stop propagation:
+// once a handler returns true, the event is not passed to the subscribers
+// after it.
+func (em *EventManager) SubscribeHandler(eventType string, handler func(interface{}) bool, priority ...int) uint64 {
+    return em.add(&subscription{eventType: eventType, priority: priorityOf(priority), handler: handler})
+}
+
+// add assigns the subscription an ID and inserts it after every subscription
+// of the same or a higher priority.
+func (em *EventManager) add(added *subscription) uint64 {
+    em.mu.Lock()
+    defer em.mu.Unlock()
 
     id := em.nextID
-    em.subscribers[eventType][id] = callback
     em.nextID++
+    added.id = id
+    eventType := added.eventType
+    if added.scoped {
+        em.byEntity[added.entity] = append(em.byEntity[added.entity], added)
+    }
+
+    list := em.subscribers[eventType]
+    at := len(list)
+    for i, sub := range list {
+        if sub.priority < added.priority {
+            at = i
+            break
+        }
+    }
+    updated := make([]*subscription, 0, len(list)+1)
+    updated = append(updated, list[:at]...)
+    updated = append(updated, added)
+    em.subscribers[eventType] = append(updated, list[at:]...)
 
     fmt.Printf("Subscribed to %s with ID %d\n", eventType, id)
     return id
 }
 
+// priorityOf reads the optional priority argument of the Subscribe
+// functions.
+func priorityOf(priority []int) int {
+    switch len(priority) {
+    case 0:
+        return 0
+    case 1:
+        return priority[0]
+    }
+    panic("ecs: more than one subscription priority")
+}
+
 func (em *EventManager) Unsubscribe(eventType string, id uint64) {
     em.mu.Lock()
     defer em.mu.Unlock()
 
-    if callbacks, exists := em.subscribers[eventType]; exists {
-        delete(callbacks, id)
-        fmt.Printf("Unsubscribed from %s with ID %d\n", eventType, id)
+    for _, sub := range em.subscribers[eventType] {
+        if sub.id == id {
+            em.remove(sub)
+            if sub.scoped {
+                em.byEntity[sub.entity] = without(em.byEntity[sub.entity], sub)
+                if len(em.byEntity[sub.entity]) == 0 {
+                    delete(em.byEntity, sub.entity)
+                }
+            }
+            return
+        }
+    }
+}
+
+// remove takes the subscription out of its event type's list. Callers must
+// hold the write lock.
+func (em *EventManager) remove(sub *subscription) {
+    sub.removed.Store(true)
+    em.subscribers[sub.eventType] = without(em.subscribers[sub.eventType], sub)
+    fmt.Printf("Unsubscribed from %s with ID %d\n", sub.eventType, sub.id)
+}
+
+// dropEntity removes every subscription scoped to the entity.
+func (em *EventManager) dropEntity(entity Entity) {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+
+    for _, sub := range em.byEntity[entity] {
+        em.remove(sub)
+    }
+    delete(em.byEntity, entity)
+}
+
+// dropScoped removes every entity-scoped subscription, for when the world's
+// entities are replaced wholesale.
+func (em *EventManager) dropScoped() {
+    em.mu.Lock()
+    defer em.mu.Unlock()
+
+    for entity, subs := range em.byEntity {
+        for _, sub := range subs {
+            em.remove(sub)
+        }
+        delete(em.byEntity, entity)
     }
 }
 
+// without returns a copy of list without sub.
+func without(list []*subscription, sub *subscription) []*subscription {
+    updated := make([]*subscription, 0, len(list))
+    for _, other := range list {
+        if other != sub {
+            updated = append(updated, other)
+        }
+    }
+    return updated
+}
+
+// Publish delivers the event to its subscribers on the calling goroutine
+// and returns once they have all run. Publishes from different goroutines
+// are delivered concurrently. An event published by a callback is delivered
+// right away, before that callback continues.
 func (em *EventManager) Publish(eventType string, data interface{}) {
+    if em.depth.Add(1) > maxDepth {
+        em.depth.Add(-1)
+        em.report(fmt.Errorf("%w (publishing %s)", ErrEventCascade, eventType))
+        return
+    }
+    defer em.depth.Add(-1)
+    em.deliver(eventType, data)
+}
+
+// deliver calls the event's subscribers without holding any lock, skipping
+// those that unsubscribed while earlier callbacks ran or whose filter
+// rejects the event, until one reports the event handled. Once an entity's
+// "EntityDestroyed" event is delivered, the subscriptions scoped to it are
+// dropped.
+func (em *EventManager) deliver(eventType string, data interface{}) {
     em.mu.RLock()
-    defer em.mu.RUnlock()
+    list := em.subscribers[eventType]
+    em.mu.RUnlock()
+
+    for _, sub := range list {
+        if !sub.removed.Load() && em.call(eventType, data, sub) {
+            break
+        }
+    }
+
+    if destroyed, ok := data.(EntityDestroyedEvent); ok && eventType == destroyed.EventName() {
+        em.dropEntity(destroyed.Entity)
+    }
+}
 
-    if callbacks, exists := em.subscribers[eventType]; exists {
-        for _, callback := range callbacks {
-            callback(data)
+// call runs the subscriber's filter and handler, reporting a panic as an
+// unhandled event.
+func (em *EventManager) call(eventType string, data interface{}, sub *subscription) (handled bool) {
+    defer func() {
+        if value := recover(); value != nil {
+            em.report(&SubscriberPanicError{
+                EventType: eventType,
+                ID:        sub.id,
+                Value:     value,
+                Stack:     debug.Stack(),
+            })
         }
+    }()
+    if sub.filter != nil && !sub.filter(data) {
+        return false
     }
-}
\ No newline at end of file
+    return sub.handler(data)
+}
+
+func (em *EventManager) report(err error) {
+    em.mu.RLock()
+    handler := em.errorHandler
+    em.mu.RUnlock()
+
+    if handler == nil {
+        fmt.Fprintln(os.Stderr, err)
+        var panicErr *SubscriberPanicError
+        if errors.As(err, &panicErr) {
+            os.Stderr.Write(panicErr.Stack)
+        }
+        return
+    }
+    handler(err)
+}
+
+// NamedEvent is implemented by event types that are published under a name
+// other than their Go type, such as BreathEvent under "EntityBreathed".
+// EventName must not depend on the receiver's fields.
+type NamedEvent interface {
+    EventName() string
+}
+
+// eventNames caches the name of each event type, keyed by a typed nil pointer
+// like componentTypes.
+var eventNames sync.Map
+
+// EventName returns the name events of type E are published under: the
+// result of EventName when E implements NamedEvent, otherwise the Go type,
+// e.g. "ecs.EntityDestroyedEvent".
+func EventName[E any]() string {
+    key := (*E)(nil)
+    if name, ok := eventNames.Load(key); ok {
+        return name.(string)
+    }
+
+    t := reflect.TypeOf(key).Elem()
+    value := reflect.New(t).Elem()
+    if t.Kind() == reflect.Pointer {
+        value = reflect.New(t.Elem())
+    }
+    name := t.String()
+    if named, ok := value.Interface().(NamedEvent); ok {
+        name = named.EventName()
+    }
+    eventNames.Store(key, name)
+    return name
+}
+
+// Subscribe registers a typed callback for events of type E, with an
+// optional priority as for EventManager.Subscribe. It shares the name
+// returned by EventName with the string API, so callback also receives
+// events of type E published with EventManager.Publish; payloads of any other
+// type are skipped.
+func Subscribe[E any](em *EventManager, callback func(E), priority ...int) uint64 {
+    return SubscribeHandler(em, func(event E) bool {
+        callback(event)
+        return false
+    }, priority...)
+}
+
+// SubscribeHandler registers a typed handler that may stop propagation, as
+// for EventManager.SubscribeHandler.
+func SubscribeHandler[E any](em *EventManager, handler func(E) bool, priority ...int) uint64 {
+    return em.SubscribeHandler(EventName[E](), func(data interface{}) bool {
+        if event, ok := data.(E); ok {
+            return handler(event)
+        }
+        return false
+    }, priority...)
+}
+
+// Unsubscribe removes a subscription made with Subscribe[E] or
+// SubscribeHandler[E].
+func Unsubscribe[E any](em *EventManager, id uint64) {
+    em.Unsubscribe(EventName[E](), id)
+}
+
+// Publish delivers the event to the subscribers of its type, whether they
+// subscribed with Subscribe[E] or by name.
+func Publish[E any](em *EventManager, event E) {
+    em.Publish(EventName[E](), event)
+}
+
+// EntityEvent is implemented by events about one entity. Subscriptions made
+// with SubscribeEntity and SubscribeQuery only receive events that implement
+// it.
+type EntityEvent interface {
+    EventEntity() Entity
+}
+
+// SubscribeEntity registers a typed callback for events of type E about one
+// entity of the world. The subscription is dropped after the entity's
+// EntityDestroyedEvent has been delivered, or when LoadState, ReadSnapshot or
+// Recover replace the world's entities; it can also be removed earlier with
+// Unsubscribe[E]. If the entity is not alive, nothing is subscribed and 0 is
+// returned.
+func SubscribeEntity[E EntityEvent](w *World, entity Entity, callback func(E), priority ...int) uint64 {
+    if !w.IsAlive(entity) {
+        return 0
+    }
+    em := w.EventManager
+    id := em.add(&subscription{
+        eventType: EventName[E](),
+        priority:  priorityOf(priority),
+        handler:   typedCallback(callback),
+        filter: func(data interface{}) bool {
+            event, ok := data.(EntityEvent)
+            return ok && event.EventEntity() == entity
+        },
+        scoped: true,
+        entity: entity,
+    })
+    // The entity may have been destroyed, and its event delivered, since
+    // the check above.
+    if !w.IsAlive(entity) {
+        em.Unsubscribe(EventName[E](), id)
+        return 0
+    }
+    return id
+}
+
+// SubscribeQuery registers a typed callback for events of type E about
+// entities that match the query when the event is delivered. Only the
+// query's With, Without and AnyOf terms are considered. The event must not be
+// published while the query is being iterated on the same goroutine.
+func SubscribeQuery[E EntityEvent](em *EventManager, query *Query, callback func(E), priority ...int) uint64 {
+    return em.add(&subscription{
+        eventType: EventName[E](),
+        priority:  priorityOf(priority),
+        handler:   typedCallback(callback),
+        filter: func(data interface{}) bool {
+            event, ok := data.(EntityEvent)
+            return ok && query.Contains(event.EventEntity())
+        },
+    })
+}
+
+// typedCallback adapts a typed callback to the handler of a subscription,
+// skipping payloads of other types.
+func typedCallback[E any](callback func(E)) func(interface{}) bool {
+    return func(data interface{}) bool {
+        if event, ok := data.(E); ok {
+            callback(event)
+        }
+        return false
+    }
+}
@@ -0,0 +1,254 @@
+package ecs
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+type testPing struct{ N int }
+
+func TestTypedEvents(t *testing.T) {
+	em := NewEventManager()
+	var got []int
+	id := Subscribe(em, func(event testPing) { got = append(got, event.N) })
+
+	Publish(em, testPing{N: 1})
+	em.Publish(EventName[testPing](), testPing{N: 2})
+	em.Publish(EventName[testPing](), "not a ping")
+	Unsubscribe[testPing](em, id)
+	Publish(em, testPing{N: 3})
+
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("typed subscriber received %v, want [1 2]", got)
+	}
+}
+
+func TestNamedEventsReachStringSubscribers(t *testing.T) {
+	if name := EventName[BreathEvent](); name != "EntityBreathed" {
+		t.Errorf("EventName[BreathEvent] = %q", name)
+	}
+	if name := EventName[*testPing](); name != "*ecs.testPing" {
+		t.Errorf("EventName[*testPing] = %q", name)
+	}
+
+	em := NewEventManager()
+	var got []Entity
+	em.Subscribe("EntityBreathed", func(data interface{}) {
+		got = append(got, data.(BreathEvent).Entity)
+	})
+	Publish(em, BreathEvent{Entity: 7})
+	if len(got) != 1 || got[0] != 7 {
+		t.Errorf("string subscriber received %v, want [7]", got)
+	}
+}
+
+func TestCallbacksMaySubscribeAndPublish(t *testing.T) {
+	em := NewEventManager()
+	var got []int
+	var self uint64
+	self = Subscribe(em, func(event testPing) {
+		got = append(got, event.N)
+		// None of these may deadlock. The nested event is delivered before
+		// Publish returns, to the subscriber added here but not to this one.
+		Subscribe(em, func(event testPing) { got = append(got, -event.N) })
+		Unsubscribe[testPing](em, self)
+		Publish(em, testPing{N: event.N + 1})
+		got = append(got, 0)
+	})
+
+	Publish(em, testPing{N: 1})
+	if want := []int{1, -2, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestSubscriberPanicIsReported(t *testing.T) {
+	em := NewEventManager()
+	var reported []error
+	em.SetErrorHandler(func(err error) { reported = append(reported, err) })
+	calls := 0
+	em.Subscribe("Ping", func(interface{}) { panic("boom") })
+	em.Subscribe("Ping", func(interface{}) { calls++ })
+
+	em.Publish("Ping", nil)
+	em.Publish("Ping", nil)
+	if calls != 2 {
+		t.Errorf("healthy subscriber called %d times, want 2", calls)
+	}
+	if len(reported) != 2 {
+		t.Fatalf("%d panics reported, want 2", len(reported))
+	}
+	var panicErr *SubscriberPanicError
+	if !errors.As(reported[0], &panicErr) || panicErr.EventType != "Ping" || panicErr.Value != "boom" {
+		t.Errorf("reported %v", reported[0])
+	}
+}
+
+func TestSubscribersRunByPriorityThenSubscriptionOrder(t *testing.T) {
+	em := NewEventManager()
+	var order []string
+	record := func(name string) func(testPing) {
+		return func(testPing) { order = append(order, name) }
+	}
+	Subscribe(em, record("a"))
+	Subscribe(em, record("b"), 10)
+	Subscribe(em, record("c"))
+	Subscribe(em, record("d"), -1)
+	Subscribe(em, record("e"), 10)
+
+	for i := 0; i < 3; i++ {
+		order = order[:0]
+		Publish(em, testPing{})
+		if want := []string{"b", "e", "a", "c", "d"}; !reflect.DeepEqual(order, want) {
+			t.Fatalf("call order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestHandlerStopsPropagation(t *testing.T) {
+	em := NewEventManager()
+	var order []int
+	Subscribe(em, func(event testPing) { order = append(order, 1) }, 2)
+	SubscribeHandler(em, func(event testPing) bool {
+		order = append(order, 2)
+		return event.N > 0
+	}, 1)
+	Subscribe(em, func(event testPing) { order = append(order, 3) })
+
+	Publish(em, testPing{N: 0})
+	Publish(em, testPing{N: 1})
+	if want := []int{1, 2, 3, 1, 2}; !reflect.DeepEqual(order, want) {
+		t.Errorf("call order %v, want %v", order, want)
+	}
+}
+
+func TestEntitySubscriptionIsDroppedWithItsEntity(t *testing.T) {
+	w := NewWorld()
+	watched, other := w.CreateEntity(), w.CreateEntity()
+	var got []Entity
+	SubscribeEntity(w, watched, func(event BreathEvent) { got = append(got, event.Entity) })
+	destroyed := 0
+	SubscribeEntity(w, watched, func(EntityDestroyedEvent) { destroyed++ })
+
+	Publish(w.EventManager, BreathEvent{Entity: watched})
+	Publish(w.EventManager, BreathEvent{Entity: other})
+	w.DestroyEntity(watched)
+	Publish(w.EventManager, BreathEvent{Entity: watched})
+
+	if len(got) != 1 || got[0] != watched {
+		t.Errorf("entity subscriber received %v, want [%d]", got, watched)
+	}
+	if destroyed != 1 {
+		t.Errorf("EntityDestroyed delivered %d times to the entity's subscriber, want 1", destroyed)
+	}
+	if n := len(w.EventManager.subscribers[EventName[BreathEvent]()]); n != 0 {
+		t.Errorf("%d subscriptions left after the entity was destroyed", n)
+	}
+}
+
+func TestQuerySubscriptionFiltersByComponents(t *testing.T) {
+	w := NewWorld()
+	breathing, silent := w.CreateEntity(), w.CreateEntity()
+	w.AddComponent(breathing, &components.Lung{})
+	w.AddComponent(breathing, &components.Mouth{})
+	w.AddComponent(silent, &components.Lung{})
+
+	query := w.NewQuery().With(TypeOf[*components.Lung]()).Without(TypeOf[*components.Mouth]()).Build()
+	var got []Entity
+	SubscribeQuery(w.EventManager, query, func(event BreathEvent) { got = append(got, event.Entity) })
+
+	Publish(w.EventManager, BreathEvent{Entity: breathing})
+	Publish(w.EventManager, BreathEvent{Entity: silent})
+	w.RemoveComponent(breathing, TypeOf[*components.Mouth]())
+	Publish(w.EventManager, BreathEvent{Entity: breathing})
+
+	if want := []Entity{silent, breathing}; !reflect.DeepEqual(got, want) {
+		t.Errorf("query subscriber received %v, want %v", got, want)
+	}
+}
+
+func TestPublishFromAnotherGoroutineWaitsForItsSubscribers(t *testing.T) {
+	em := NewEventManager()
+	started, release := make(chan struct{}), make(chan struct{})
+	em.Subscribe("Slow", func(interface{}) {
+		close(started)
+		<-release
+	})
+	var delivered atomic.Bool
+	Subscribe(em, func(testPing) { delivered.Store(true) })
+
+	go em.Publish("Slow", nil)
+	<-started
+	// Another goroutine is delivering; this event must still be delivered
+	// here, before Publish returns.
+	Publish(em, testPing{})
+	if !delivered.Load() {
+		t.Error("Publish returned before its subscribers ran")
+	}
+	close(release)
+}
+
+func TestEndlessCascadeIsCutOff(t *testing.T) {
+	em := NewEventManager()
+	var reported []error
+	em.SetErrorHandler(func(err error) { reported = append(reported, err) })
+	calls := 0
+	Subscribe(em, func(event testPing) {
+		calls++
+		Publish(em, testPing{N: event.N + 1})
+	})
+
+	Publish(em, testPing{})
+	if calls != maxDepth {
+		t.Errorf("%d events delivered, want %d", calls, maxDepth)
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], ErrEventCascade) {
+		t.Errorf("reported %v, want one ErrEventCascade", reported)
+	}
+
+	// The limit counts deliveries in progress, not events ever published.
+	calls = 0
+	Publish(em, testPing{})
+	if calls != maxDepth {
+		t.Errorf("%d events delivered after the cutoff, want %d", calls, maxDepth)
+	}
+}
+
+func BenchmarkPublish(b *testing.B) {
+	em := NewEventManager()
+	Subscribe(em, func(BreathEvent) {})
+	event := BreathEvent{Entity: 1}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Publish(em, event)
+	}
+}
+
+func TestEntitySubscriptionsDoNotSurviveLoad(t *testing.T) {
+	w, entities := populatedWorld(t)
+	data, err := w.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	calls := 0
+	SubscribeEntity(w, entities[0], func(BreathEvent) { calls++ })
+	if id := SubscribeEntity(w, entities[1], func(BreathEvent) { calls++ }); id != 0 {
+		t.Errorf("subscribed to dead entity %d with ID %d", entities[1], id)
+	}
+
+	if err := w.LoadState(data); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	Publish(w.EventManager, BreathEvent{Entity: entities[0]})
+	if calls != 0 {
+		t.Errorf("entity subscription survived LoadState")
+	}
+	if len(w.EventManager.byEntity) != 0 {
+		t.Errorf("%d entities still have subscriptions", len(w.EventManager.byEntity))
+	}
+}
@@ -0,0 +1,141 @@
+// internal/ecs/events.go
+
+package ecs
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Events is the double-buffered queue of one event type. Events sent during
+// a tick can be read for the rest of that tick and during the next one, and
+// are dropped when the tick after that starts. Systems use it through an
+// EventWriter and EventReaders rather than directly.
+//
+// Unlike EventManager, nothing runs when an event is sent: readers poll the
+// queue when they run, so they may touch the world freely. Sending and
+// reading are synchronized, so systems need not declare the queue in their
+// Access.
+type Events[T any] struct {
+	previous []T    // sent during the previous tick
+	current  []T    // sent during this tick
+	start    uint64 // sequence number of previous[0]
+	mu       sync.Mutex
+}
+
+// eventQueue is the type-independent part of Events, used to swap the
+// buffers of every queue at the start of a tick.
+type eventQueue interface {
+	swap()
+}
+
+func (e *Events[T]) send(event T) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.current = append(e.current, event)
+}
+
+// swap drops the events of the previous tick and starts a new current
+// buffer, reusing the dropped one's storage.
+func (e *Events[T]) swap() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.start += uint64(len(e.previous))
+	clear(e.previous) // let the garbage collector have the dropped events
+	e.previous, e.current = e.current, e.previous[:0]
+}
+
+// readFrom returns the events with sequence numbers from next on, oldest
+// first, and the sequence number after the last of them.
+func (e *Events[T]) readFrom(next uint64) ([]T, uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if next < e.start {
+		next = e.start // the reader missed events that were already dropped
+	}
+	skip := int(next - e.start)
+	var result []T
+	if skip < len(e.previous) {
+		result = append(result, e.previous[skip:]...)
+		skip = 0
+	} else {
+		skip -= len(e.previous)
+	}
+	result = append(result, e.current[skip:]...)
+	return result, e.start + uint64(len(e.previous)+len(e.current))
+}
+
+// eventQueues holds the world's Events, one per event type.
+type eventQueues struct {
+	byType map[reflect.Type]eventQueue
+	mu     sync.Mutex
+}
+
+func newEventQueues() *eventQueues {
+	return &eventQueues{byType: make(map[reflect.Type]eventQueue)}
+}
+
+// swap starts a new tick in every queue.
+func (q *eventQueues) swap() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, queue := range q.byType {
+		queue.swap()
+	}
+}
+
+// eventsOf returns the world's queue for T, creating it on first use.
+func eventsOf[T any](w *World) *Events[T] {
+	q := w.events
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	eventType := reflect.TypeOf((*T)(nil)).Elem()
+	if queue, exists := q.byType[eventType]; exists {
+		return queue.(*Events[T])
+	}
+	events := &Events[T]{}
+	q.byType[eventType] = events
+	return events
+}
+
+// EventWriter sends events of type T to the world's queue for T.
+type EventWriter[T any] struct {
+	events *Events[T]
+}
+
+// NewEventWriter returns a writer for the world's T events. Systems usually
+// create it in their constructor, like a query.
+func NewEventWriter[T any](w *World) *EventWriter[T] {
+	return &EventWriter[T]{events: eventsOf[T](w)}
+}
+
+// Send queues the event for readers.
+func (ew *EventWriter[T]) Send(event T) {
+	ew.events.send(event)
+}
+
+// EventReader reads the world's T events. Each reader keeps its own cursor,
+// so every reader sees every event once, as long as it reads at least once
+// per tick.
+type EventReader[T any] struct {
+	events *Events[T]
+	next   uint64
+}
+
+// NewEventReader returns a reader for the world's T events. Its first Read
+// returns the events still queued when it is called.
+func NewEventReader[T any](w *World) *EventReader[T] {
+	return &EventReader[T]{events: eventsOf[T](w)}
+}
+
+// Read returns the events sent since the previous Read, oldest first. A
+// reader that skipped more than a tick has missed the events dropped in the
+// meantime.
+func (er *EventReader[T]) Read() []T {
+	var events []T
+	events, er.next = er.events.readFrom(er.next)
+	return events
+}
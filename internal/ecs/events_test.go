@@ -0,0 +1,68 @@
+package ecs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+func TestEventReaderSeesEventsForTwoTicks(t *testing.T) {
+	w := NewWorld()
+	writer := NewEventWriter[testPing](w)
+	early := NewEventReader[testPing](w)
+	late := NewEventReader[testPing](w)
+
+	w.Update(0.1)
+	writer.Send(testPing{N: 1})
+	writer.Send(testPing{N: 2})
+	if got := early.Read(); !reflect.DeepEqual(got, []testPing{{1}, {2}}) {
+		t.Errorf("same tick: read %v", got)
+	}
+
+	w.Update(0.1)
+	writer.Send(testPing{N: 3})
+	if got := early.Read(); !reflect.DeepEqual(got, []testPing{{3}}) {
+		t.Errorf("next tick: read %v, want only the new event", got)
+	}
+	if got := late.Read(); !reflect.DeepEqual(got, []testPing{{1}, {2}, {3}}) {
+		t.Errorf("reader lagging a tick: read %v", got)
+	}
+
+	w.Update(0.1)
+	w.Update(0.1)
+	if got := NewEventReader[testPing](w).Read(); len(got) != 0 {
+		t.Errorf("events still queued after two ticks: %v", got)
+	}
+	if got := early.Read(); len(got) != 0 {
+		t.Errorf("reader saw events twice: %v", got)
+	}
+}
+
+func TestBreathingSystemSendsEvents(t *testing.T) {
+	w, entities := populatedWorld(t)
+	reader := NewEventReader[BreathEvent](w)
+	var published []Entity
+	Subscribe(w.EventManager, func(event BreathEvent) {
+		published = append(published, event.Entity)
+	})
+	w.AddSystem(NewBreathingSystem(w))
+	w.Update(0.1)
+
+	// Only entities[0] has both a lung and a mouth; entities[2] has just a
+	// lung and entities[3] and entities[6] just a mouth.
+	want := []Entity{entities[0]}
+	var sent []Entity
+	for _, event := range reader.Read() {
+		sent = append(sent, event.Entity)
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("BreathEvents sent for %v, want %v", sent, want)
+	}
+	if !reflect.DeepEqual(published, want) {
+		t.Errorf("BreathEvents published for %v, want %v", published, want)
+	}
+	if lung, _ := Get[*components.Lung](w, entities[2]); lung.Volume != 2 {
+		t.Errorf("lung without a mouth breathed: volume %v", lung.Volume)
+	}
+}
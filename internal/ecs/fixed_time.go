@@ -0,0 +1,90 @@
+// internal/ecs/fixed_time.go
+
+package ecs
+
+import "math"
+
+// Default fixed-timestep settings used until SetFixedTimestep is called.
+const (
+	DefaultFixedStep     = float32(1.0 / 60.0)
+	DefaultMaxFixedSteps = 5
+)
+
+// FixedTime is the state of the fixed-timestep loop, kept as a world
+// resource. Systems that render or sample state between fixed updates read
+// Alpha to interpolate between the previous and current step.
+type FixedTime struct {
+	Step     float32 // seconds simulated by each fixed update
+	MaxSteps int     // fixed updates allowed per frame; 0 means no limit
+	Alpha    float32 // fraction of a step left in the accumulator, in [0, 1)
+	Steps    int     // fixed updates run during the last frame
+
+	accumulator float64
+}
+
+// SetFixedTimestep sets the fixed update length and the per-frame step limit.
+// Time already accumulated is kept.
+func (w *World) SetFixedTimestep(step float32, maxSteps int) {
+	if step <= 0 {
+		panic("ecs: fixed timestep must be positive")
+	}
+	fixed := resourcePtr[FixedTime](w)
+	fixed.Step = step
+	fixed.MaxSteps = maxSteps
+}
+
+// FixedTime returns a copy of the fixed-timestep state.
+func (w *World) FixedTime() FixedTime {
+	return *resourcePtr[FixedTime](w)
+}
+
+// RunFrame advances the world by frameTime seconds of real time, scaled by
+// the Time resource. Stages before StageFixedUpdate run once,
+// StageFixedUpdate runs once per whole step in the accumulator with dt set to
+// the step, and the remaining stages run once with dt set to the scaled frame
+// time. If more than MaxSteps steps are due, the excess time is dropped so a
+// slow frame cannot snowball into slower ones. A frame run through Step while
+// paused simulates exactly one fixed update. It returns the number of fixed
+// updates run.
+func (w *World) RunFrame(frameTime float32) int {
+	clock := resourcePtr[Time](w)
+	stepping := clock.Paused
+	if !clock.begin() {
+		return 0
+	}
+
+	fixed := resourcePtr[FixedTime](w)
+	frameTime *= clock.Scale
+	if stepping {
+		frameTime = fixed.Step
+	}
+	clock.advance(frameTime, w.entityManager.AdvanceTick())
+	w.events.swap()
+	w.scheduler.RunStages(StageFirst, StagePreUpdate, frameTime)
+
+	steps := 0
+	step := float64(fixed.Step)
+	if stepping {
+		w.scheduler.RunStages(StageFixedUpdate, StageFixedUpdate, fixed.Step)
+		steps = 1
+	} else {
+		fixed.accumulator += float64(frameTime)
+		for fixed.accumulator >= step {
+			if fixed.MaxSteps > 0 && steps == fixed.MaxSteps {
+				fixed.accumulator = math.Mod(fixed.accumulator, step)
+				break
+			}
+			w.scheduler.RunStages(StageFixedUpdate, StageFixedUpdate, fixed.Step)
+			fixed.accumulator -= step
+			steps++
+		}
+	}
+	fixed.Steps = steps
+	fixed.Alpha = float32(fixed.accumulator / step)
+
+	w.scheduler.RunStages(StageUpdate, StageLast, frameTime)
+	if w.journal != nil {
+		w.journal.endTick()
+	}
+	return steps
+}
@@ -0,0 +1,88 @@
+package ecs
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// dtRecorder records the dt of every update, in stage order.
+type dtRecorder struct {
+	dts []float32
+}
+
+func (r *dtRecorder) Update(dt float32) { r.dts = append(r.dts, dt) }
+
+// The frame times below are exact binary fractions, so the accumulator has
+// no rounding to account for.
+
+func TestRunFrameRunsWholeSteps(t *testing.T) {
+	w := NewWorld()
+	w.SetFixedTimestep(0.25, 0)
+
+	for _, frame := range []struct {
+		time  float32
+		steps int
+		alpha float32
+	}{
+		{0.625, 2, 0.5},
+		{0.125, 1, 0},
+		{0.125, 0, 0.5},
+		{1, 4, 0.5},
+	} {
+		if steps := w.RunFrame(frame.time); steps != frame.steps {
+			t.Errorf("RunFrame(%v) = %d steps, want %d", frame.time, steps, frame.steps)
+		}
+		if fixed := w.FixedTime(); fixed.Steps != frame.steps || fixed.Alpha != frame.alpha {
+			t.Errorf("after RunFrame(%v): Steps %d, Alpha %v; want %d, %v",
+				frame.time, fixed.Steps, fixed.Alpha, frame.steps, frame.alpha)
+		}
+	}
+}
+
+func TestRunFrameDropsTimeBeyondMaxSteps(t *testing.T) {
+	w := NewWorld()
+	w.SetFixedTimestep(0.25, 3)
+
+	if steps := w.RunFrame(2.125); steps != 3 {
+		t.Fatalf("RunFrame = %d steps, want the limit of 3", steps)
+	}
+	// The whole steps beyond the limit are dropped; the fraction is kept.
+	if alpha := w.FixedTime().Alpha; alpha != 0.5 {
+		t.Errorf("Alpha = %v, want 0.5", alpha)
+	}
+	if steps := w.RunFrame(0.125); steps != 1 {
+		t.Errorf("next RunFrame = %d steps, want 1", steps)
+	}
+}
+
+func TestRunFrameAlphaStaysInRange(t *testing.T) {
+	w := NewWorld()
+	w.SetFixedTimestep(DefaultFixedStep, 0)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		w.RunFrame(random.Float32() / 20)
+		if alpha := w.FixedTime().Alpha; alpha < 0 || alpha >= 1 {
+			t.Fatalf("frame %d: Alpha = %v, want [0, 1)", i, alpha)
+		}
+	}
+}
+
+func TestRunFrameStageDeltas(t *testing.T) {
+	w := NewWorld()
+	w.SetFixedTimestep(0.25, 0)
+	fixed, variable := &dtRecorder{}, &dtRecorder{}
+	w.AddSystem(fixed, InStage(StageFixedUpdate))
+	w.AddSystem(variable, InStage(StageUpdate))
+
+	w.RunFrame(0.625)
+	if want := []float32{0.25, 0.25}; !reflect.DeepEqual(fixed.dts, want) {
+		t.Errorf("fixed update dts %v, want %v", fixed.dts, want)
+	}
+	if want := []float32{0.625}; !reflect.DeepEqual(variable.dts, want) {
+		t.Errorf("update dts %v, want %v", variable.dts, want)
+	}
+	if tick := w.Tick(); tick != 1 {
+		t.Errorf("tick = %d after one frame, want 1", tick)
+	}
+}
@@ -0,0 +1,62 @@
+// internal/ecs/generic.go
+
+package ecs
+
+import (
+	"reflect"
+	"sync"
+)
+
+// componentTypes caches reflect.Type per component type, keyed by a typed nil
+// pointer so lookups need neither reflection nor allocation.
+var componentTypes sync.Map
+
+// TypeOf returns the reflect.Type used as the storage key for component type
+// T, e.g. TypeOf[*components.Lung]().
+func TypeOf[T Component]() reflect.Type {
+	key := (*T)(nil)
+	if t, ok := componentTypes.Load(key); ok {
+		return t.(reflect.Type)
+	}
+	t := reflect.TypeOf(key).Elem()
+	componentTypes.Store(key, t)
+	return t
+}
+
+// Add attaches the component to the entity, replacing any existing component
+// of the same type.
+func Add[T Component](w *World, entity Entity, component T) {
+	componentType := TypeOf[T]()
+	if componentType.Kind() == reflect.Interface {
+		// Add[Component] and the like: store under the dynamic type.
+		componentType = reflect.TypeOf(component)
+	}
+	w.entityManager.addComponent(entity, componentType, component)
+}
+
+// Get returns the entity's component of type T.
+func Get[T Component](w *World, entity Entity) (T, bool) {
+	component, exists := w.entityManager.GetComponent(entity, TypeOf[T]())
+	if !exists {
+		var zero T
+		return zero, false
+	}
+	return component.(T), true
+}
+
+// Has reports whether the entity has a component of type T.
+func Has[T Component](w *World, entity Entity) bool {
+	_, exists := w.entityManager.GetComponent(entity, TypeOf[T]())
+	return exists
+}
+
+// Remove detaches the entity's component of type T, if any.
+func Remove[T Component](w *World, entity Entity) {
+	w.entityManager.RemoveComponent(entity, TypeOf[T]())
+}
+
+// GetOrAdd returns the entity's component of type T, first attaching a zeroed
+// (or pooled) one if the entity does not have it yet.
+func GetOrAdd[T Component](w *World, entity Entity) T {
+	return w.entityManager.GetOrAddComponent(entity, TypeOf[T]()).(T)
+}
@@ -0,0 +1,26 @@
+package ecs
+
+import (
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+func TestAddStoresUnderComponentType(t *testing.T) {
+	w := NewWorld()
+	entity := w.CreateEntity()
+	Add(w, entity, &components.Lung{Capacity: 2})
+	Add[Component](w, entity, &components.Mouth{IsOpen: true})
+
+	if lung, ok := Get[*components.Lung](w, entity); !ok || lung.Capacity != 2 {
+		t.Errorf("Get lung = %v, %v", lung, ok)
+	}
+	if mouth, ok := Get[*components.Mouth](w, entity); !ok || !mouth.IsOpen {
+		t.Errorf("component added through an interface type = %v, %v", mouth, ok)
+	}
+
+	Add(w, entity, &components.Lung{Capacity: 3})
+	if lung, _ := Get[*components.Lung](w, entity); lung.Capacity != 3 {
+		t.Errorf("Add did not replace the lung: capacity %v", lung.Capacity)
+	}
+}
@@ -0,0 +1,620 @@
+// internal/ecs/journal.go
+
+package ecs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+// A journal directory holds two files:
+//
+//	checkpoint  magic "STRC", uint64 generation, then a binary snapshot
+//	journal     magic "STRJ", uint16 format version, uint64 generation, then
+//	            records
+//
+// Each record is framed as a varint payload length, the payload and the
+// CRC-32 of the payload. A record whose frame is cut short or whose checksum
+// does not match ends the journal: it is the torn tail of a write that was
+// interrupted by a crash. The journal only applies to the checkpoint of the
+// same generation, so a crash between writing a new checkpoint and starting
+// its journal loses nothing.
+const (
+	checkpointFile  = "checkpoint"
+	journalFile     = "journal"
+	checkpointMagic = "STRC"
+	journalMagic    = "STRJ"
+	journalVersion  = 1
+)
+
+// Journal record kinds. The first byte of each payload is the kind.
+const (
+	recordType     byte = iota + 1 // a type table entry, referenced by index afterwards
+	recordCreate                   // entity
+	recordDestroy                  // entity
+	recordAdd                      // entity, type index, component encoding
+	recordUpdate                   // entity, type index, component encoding
+	recordRemove                   // entity, type index
+	recordTick                     // world tick
+	recordResource                 // resource name, JSON encoding
+)
+
+// JournalOptions configure a Journal.
+type JournalOptions struct {
+	// CheckpointInterval is the number of world ticks between automatic
+	// checkpoints; 0 disables them.
+	CheckpointInterval uint64
+	// SyncEveryTick makes every tick durable by syncing the journal file to
+	// disk at the end of each World.Update or RunFrame. Otherwise records are
+	// flushed to the operating system at the end of each tick and synced by
+	// Sync, Checkpoint and Close.
+	SyncEveryTick bool
+}
+
+// Journal is an append-only log of the mutations made to a World since its
+// last checkpoint. Creating, destroying, adding, updating and removing go
+// through the EntityManager and are logged as they happen. Components changed
+// in place through a pointer are logged with their value at the end of the
+// tick if they were marked changed (MarkChanged, QueryIter.MarkChanged,
+// GetMut); other in-place changes are only captured by the next checkpoint.
+// Resources are encoded at the end of every tick, and logged if their
+// encoding changed.
+type Journal struct {
+	world     *World
+	dir       string
+	options   JournalOptions
+	file      *os.File
+	out       *bufio.Writer
+	payload   bytes.Buffer
+	sw        snapshotWriter
+	frame     [binary.MaxVarintLen64]byte
+	types     map[reflect.Type]*journalType
+	dirty     map[dirtyComponent]bool
+	resources map[string]json.RawMessage // encodings as last logged or checkpointed
+	gen       uint64
+	lastTick  uint64 // tick of the last checkpoint
+	err       error  // first write error
+	mu        sync.Mutex
+}
+
+type journalType struct {
+	index      int
+	descriptor *typeDescriptor
+}
+
+type dirtyComponent struct {
+	entity        Entity
+	componentType reflect.Type
+}
+
+// StartJournal checkpoints the world into dir, creating it if needed, and
+// starts logging its mutations there. Any checkpoint and journal already in
+// dir are replaced, so call Recover first to resume from them. Every
+// component type must be registered with components.Register.
+func (w *World) StartJournal(dir string, options JournalOptions) (*Journal, error) {
+	if w.journal != nil {
+		return nil, errors.New("world already has a journal")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{world: w, dir: dir, options: options}
+	j.sw.w = &j.payload
+	if gen, err := readCheckpointGeneration(filepath.Join(dir, checkpointFile)); err == nil {
+		j.gen = gen
+	}
+
+	em := w.entityManager
+	em.lock()
+	defer em.mu.Unlock()
+	if err := j.checkpoint(); err != nil {
+		return nil, err
+	}
+	em.journal = j
+	w.journal = j
+	return j, nil
+}
+
+// Journal returns the world's journal, or nil.
+func (w *World) Journal() *Journal {
+	return w.journal
+}
+
+// Checkpoint writes a snapshot of the world and starts a new, empty journal.
+func (j *Journal) Checkpoint() error {
+	em := j.world.entityManager
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	return j.checkpoint()
+}
+
+// checkpoint is Checkpoint for callers holding the EntityManager lock.
+func (j *Journal) checkpoint() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file != nil {
+		j.out.Flush()
+		j.file.Close()
+		j.file = nil
+	}
+	gen := j.gen + 1
+
+	err := writeFileAtomic(filepath.Join(j.dir, checkpointFile), func(out io.Writer) error {
+		var header [12]byte
+		copy(header[:], checkpointMagic)
+		binary.LittleEndian.PutUint64(header[4:], gen)
+		if _, err := out.Write(header[:]); err != nil {
+			return err
+		}
+		return j.world.writeSnapshot(out)
+	})
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(j.dir, journalFile), func(out io.Writer) error {
+			var header [14]byte
+			copy(header[:], journalMagic)
+			binary.LittleEndian.PutUint16(header[4:], journalVersion)
+			binary.LittleEndian.PutUint64(header[6:], gen)
+			_, err := out.Write(header[:])
+			return err
+		})
+	}
+	if err == nil {
+		j.file, err = os.OpenFile(filepath.Join(j.dir, journalFile), os.O_WRONLY|os.O_APPEND, 0)
+	}
+	if err != nil {
+		j.fail(err)
+		return err
+	}
+
+	j.gen = gen
+	j.out = bufio.NewWriter(j.file)
+	j.types = make(map[reflect.Type]*journalType)
+	j.dirty = make(map[dirtyComponent]bool)
+	j.resources, _ = j.world.encodeResources() // the checkpoint encoded them too
+	j.lastTick = j.world.entityManager.tick.Load()
+	j.err = nil
+	return nil
+}
+
+// writeFileAtomic writes a file through a temporary file that is synced and
+// renamed over it, so readers see either the old or the new content.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(file)
+	err = write(out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func readCheckpointGeneration(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var header [12]byte
+	if _, err := io.ReadFull(file, header[:]); err != nil || string(header[:4]) != checkpointMagic {
+		return 0, fmt.Errorf("%s is not a checkpoint", path)
+	}
+	return binary.LittleEndian.Uint64(header[4:]), nil
+}
+
+// Sync flushes buffered records and syncs the journal file to disk.
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.sync()
+}
+
+func (j *Journal) sync() error {
+	if j.err == nil && j.file != nil {
+		if err := j.out.Flush(); err != nil {
+			j.fail(err)
+		} else if err := j.file.Sync(); err != nil {
+			j.fail(err)
+		}
+	}
+	return j.err
+}
+
+// Err returns the first error the journal ran into while logging. Mutations
+// cannot report errors, so check it, or the result of Sync, periodically.
+func (j *Journal) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Close logs pending changes, syncs the journal and stops logging. The world
+// keeps running without a journal.
+func (j *Journal) Close() error {
+	em := j.world.entityManager
+	em.lock()
+	defer em.mu.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.logDirty()
+	err := j.sync()
+	if j.file != nil {
+		if closeErr := j.file.Close(); err == nil {
+			err = closeErr
+		}
+		j.file = nil
+	}
+	em.journal = nil
+	j.world.journal = nil
+	return err
+}
+
+func (j *Journal) fail(err error) {
+	if j.err == nil {
+		j.err = err
+	}
+}
+
+// endTick logs the values of components marked changed during the tick, the
+// resources that changed and the tick itself, then syncs or checkpoints as configured. World.Update and
+// RunFrame call it after running the systems.
+func (j *Journal) endTick() {
+	em := j.world.entityManager
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	j.mu.Lock()
+	j.logDirty()
+	j.logResources()
+	tick := em.tick.Load()
+	j.begin(recordTick)
+	j.sw.uint64(tick)
+	j.end()
+	if j.options.SyncEveryTick {
+		j.sync()
+	} else if j.err == nil {
+		j.fail(j.out.Flush())
+	}
+	due := j.options.CheckpointInterval > 0 && tick-j.lastTick >= j.options.CheckpointInterval
+	j.mu.Unlock()
+
+	if due {
+		j.checkpoint()
+	}
+}
+
+// begin starts a record in the payload buffer. Callers hold j.mu.
+func (j *Journal) begin(kind byte) {
+	j.payload.Reset()
+	j.payload.WriteByte(kind)
+}
+
+// end frames the record in the payload buffer and appends it to the file.
+func (j *Journal) end() {
+	if j.err != nil || j.file == nil {
+		return
+	}
+	payload := j.payload.Bytes()
+	j.out.Write(j.frame[:binary.PutUvarint(j.frame[:], uint64(len(payload)))])
+	j.out.Write(payload)
+	binary.LittleEndian.PutUint32(j.frame[:4], crc32.ChecksumIEEE(payload))
+	j.out.Write(j.frame[:4])
+}
+
+// typeIndex returns the index of the component type in this journal file,
+// logging its type table entry first if needed. It returns nil if the type
+// cannot be journaled.
+func (j *Journal) journalType(componentType reflect.Type) *journalType {
+	if t, exists := j.types[componentType]; exists {
+		return t
+	}
+	name, registered := components.TypeName(componentType)
+	if !registered {
+		j.fail(fmt.Errorf("component type %v is not registered", componentType))
+		return nil
+	}
+	descriptor, err := describeType(name, componentType)
+	if err != nil {
+		j.fail(err)
+		return nil
+	}
+	t := &journalType{index: len(j.types), descriptor: descriptor}
+	j.types[componentType] = t
+
+	j.begin(recordType)
+	j.sw.typeTable([]*typeDescriptor{descriptor})
+	j.end()
+	return t
+}
+
+// The log methods are called by the EntityManager with its lock held. They
+// do nothing on a nil journal.
+
+func (j *Journal) logEntity(kind byte, entity Entity) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.begin(kind)
+	j.sw.uint32(uint32(entity))
+	j.end()
+}
+
+func (j *Journal) logComponent(kind byte, entity Entity, component Component) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.writeComponent(kind, entity, component)
+}
+
+func (j *Journal) writeComponent(kind byte, entity Entity, component Component) {
+	t := j.journalType(reflect.TypeOf(component))
+	if t == nil {
+		return
+	}
+	j.begin(kind)
+	j.sw.uint32(uint32(entity))
+	j.sw.uvarint(uint64(t.index))
+	j.sw.component(t.descriptor, component)
+	j.end()
+}
+
+func (j *Journal) logRemove(entity Entity, componentType reflect.Type) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	t := j.journalType(componentType)
+	if t == nil {
+		return
+	}
+	j.begin(recordRemove)
+	j.sw.uint32(uint32(entity))
+	j.sw.uvarint(uint64(t.index))
+	j.end()
+}
+
+// markDirty queues a component changed in place; its value is logged at the
+// end of the tick.
+func (j *Journal) markDirty(entity Entity, componentType reflect.Type) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.dirty[dirtyComponent{entity, componentType}] = true
+}
+
+// logDirty logs the current value of every queued component that still
+// exists. Callers hold the EntityManager lock and j.mu.
+func (j *Journal) logDirty() {
+	em := j.world.entityManager
+	for key := range j.dirty {
+		if record := em.record(key.entity); record != nil {
+			if column := record.archetype.column(key.componentType); column >= 0 {
+				j.writeComponent(recordUpdate, key.entity, record.archetype.components[column][record.row])
+			}
+		}
+		delete(j.dirty, key)
+	}
+}
+
+// logResources logs every resource whose encoding changed since it was last
+// logged or checkpointed. Callers hold j.mu.
+func (j *Journal) logResources() {
+	encoded, err := j.world.encodeResources()
+	if err != nil {
+		j.fail(err)
+		return
+	}
+	for name, data := range encoded {
+		if bytes.Equal(j.resources[name], data) {
+			continue
+		}
+		j.begin(recordResource)
+		j.sw.string(name)
+		j.sw.bytes(data)
+		j.end()
+		j.resources[name] = data
+	}
+}
+
+// Recover loads the checkpoint in dir and replays the journal written after
+// it, stopping quietly at a torn final record. Replayed destructions do not
+// publish events. Recover does not start journaling; call StartJournal
+// afterwards to continue logging into dir.
+func (w *World) Recover(dir string) error {
+	file, err := os.Open(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	in := bufio.NewReader(file)
+	var header [12]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil || string(header[:4]) != checkpointMagic {
+		return fmt.Errorf("%s is not a checkpoint", file.Name())
+	}
+	gen := binary.LittleEndian.Uint64(header[4:])
+	if err := w.ReadSnapshot(in); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+
+	journal, err := os.Open(filepath.Join(dir, journalFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer journal.Close()
+	return w.replay(bufio.NewReader(journal), gen)
+}
+
+// replay applies the records of a journal if it belongs to the checkpoint
+// generation.
+func (w *World) replay(in *bufio.Reader, gen uint64) error {
+	var header [14]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil || string(header[:4]) != journalMagic {
+		return nil // never got past creation; the checkpoint is complete
+	}
+	if version := binary.LittleEndian.Uint16(header[4:]); version != journalVersion {
+		return fmt.Errorf("unsupported journal version %d", version)
+	}
+	if binary.LittleEndian.Uint64(header[6:]) != gen {
+		return nil // written before the checkpoint, which already contains it
+	}
+
+	em := w.entityManager
+	var types []*typeDescriptor
+	for {
+		payload, ok := readRecord(in)
+		if !ok {
+			return nil
+		}
+		sr := &snapshotReader{r: bytes.NewReader(payload)}
+		kind := sr.byte()
+
+		switch kind {
+		case recordType:
+			table, err := sr.typeTable(snapshotVersion)
+			if err != nil {
+				return fmt.Errorf("journal: %w", err)
+			}
+			types = append(types, table...)
+			continue
+		case recordTick:
+			em.tick.Store(sr.uint64())
+			continue
+		case recordResource:
+			name, data := sr.string(), sr.bytes()
+			if sr.err != nil {
+				return fmt.Errorf("journal: %w", sr.err)
+			}
+			if err := w.replayResource(name, data); err != nil {
+				return fmt.Errorf("journal: %w", err)
+			}
+			continue
+		}
+
+		entity := Entity(sr.uint32())
+		switch kind {
+		case recordCreate:
+			em.claimEntity(entity)
+		case recordDestroy:
+			if !em.DestroyEntity(entity) {
+				// Reserved by a command buffer and destroyed before it was
+				// spawned: only the allocator saw it.
+				em.entities.claim(entity)
+				em.entities.release(entity)
+			}
+		case recordAdd, recordUpdate, recordRemove:
+			index := int(sr.uvarint())
+			if sr.err != nil || index >= len(types) {
+				return fmt.Errorf("journal names component type %d of %d", index, len(types))
+			}
+			if kind == recordRemove {
+				component, _ := components.New(types[index].name)
+				em.RemoveComponent(entity, reflect.TypeOf(component))
+				continue
+			}
+			component, err := sr.component(types[index])
+			if err != nil {
+				return fmt.Errorf("journal: entity %d: %w", entity, err)
+			}
+			if em.hasRecord(entity) {
+				em.AddComponent(entity, component)
+			}
+		default:
+			return fmt.Errorf("journal: unknown record kind %d", kind)
+		}
+		if sr.err != nil {
+			return fmt.Errorf("journal: %w", sr.err)
+		}
+	}
+}
+
+// replayResource decodes a logged resource into the world's resource of the
+// same name. Like LoadState, it ignores resources the world does not have.
+func (w *World) replayResource(name string, data []byte) error {
+	ptr, exists := w.resources.byName()[name]
+	if !exists {
+		return nil
+	}
+	value := reflect.New(reflect.TypeOf(ptr).Elem())
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return fmt.Errorf("resource %s: %w", name, err)
+	}
+	reflect.ValueOf(ptr).Elem().Set(value.Elem())
+	return nil
+}
+
+// readRecord reads one framed record. It reports false at the end of the
+// journal, including a torn or corrupt final record.
+func readRecord(in *bufio.Reader) ([]byte, bool) {
+	size, err := binary.ReadUvarint(in)
+	if err != nil || size > maxSnapshotString {
+		return nil, false
+	}
+	payload := make([]byte, size+4)
+	if _, err := io.ReadFull(in, payload); err != nil {
+		return nil, false
+	}
+	sum := binary.LittleEndian.Uint32(payload[size:])
+	payload = payload[:size]
+	if size == 0 || crc32.ChecksumIEEE(payload) != sum {
+		return nil, false
+	}
+	return payload, true
+}
+
+// claimEntity recreates an entity under the exact handle it had, for replay.
+func (em *EntityManager) claimEntity(entity Entity) {
+	em.lock()
+	defer em.mu.Unlock()
+
+	if em.entities.claim(entity) {
+		em.place(entity)
+	}
+}
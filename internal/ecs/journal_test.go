@@ -0,0 +1,169 @@
+package ecs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+// requireSameEntities fails unless both worlds hold the same entities and
+// components.
+func requireSameEntities(t *testing.T, want, got *World) {
+	t.Helper()
+	if d := Diff(snapshot(t, want), snapshot(t, got)); !d.Empty() {
+		t.Fatalf("worlds differ: %d spawned, %d despawned, %d added, %d modified, %d removed",
+			len(d.Spawned), len(d.Despawned), len(d.Added), len(d.Modified), len(d.Removed))
+	}
+}
+
+func TestJournalRecover(t *testing.T) {
+	dir := t.TempDir()
+	w, entities := populatedWorld(t)
+	journal, err := w.StartJournal(dir, JournalOptions{})
+	if err != nil {
+		t.Fatalf("StartJournal: %v", err)
+	}
+
+	created := w.CreateEntity()
+	w.AddComponent(created, &components.Lung{Capacity: 4})
+	w.UpdateComponent(entities[2], &components.Lung{Capacity: 3, Volume: 1})
+	w.RemoveComponent(entities[0], TypeOf[*components.Mouth]())
+	w.DestroyEntity(entities[3])
+	w.Update(0.1)
+
+	// Changed in place, journaled at the end of the tick.
+	lung, _ := GetMut[*components.Lung](w, entities[0])
+	lung.Volume = 0.9
+	w.Commands().Spawn(&components.Mouth{IsOpen: true})
+	w.Update(0.1)
+
+	// A crash: the journal is never closed.
+	recovered := NewWorld()
+	if err := recovered.Recover(dir); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	requireSameEntities(t, w, recovered)
+	if recovered.Tick() != w.Tick() {
+		t.Errorf("tick = %d, want %d", recovered.Tick(), w.Tick())
+	}
+	if want, got := w.CreateEntity(), recovered.CreateEntity(); want != got {
+		t.Errorf("CreateEntity after recovery = %d, want %d", got, want)
+	}
+	journal.Close()
+}
+
+func TestJournalRecoversResources(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := populatedWorld(t)
+	journal, err := w.StartJournal(dir, JournalOptions{})
+	if err != nil {
+		t.Fatalf("StartJournal: %v", err)
+	}
+	defer journal.Close()
+	for i := 0; i < 3; i++ {
+		w.Update(0.25)
+	}
+	config, _ := ResourceMut[testConfig](w)
+	config.Label = "changed"
+	w.Update(0.25)
+
+	recovered := NewWorld()
+	recovered.InsertResource(testConfig{})
+	if err := recovered.Recover(dir); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if want, got := w.Time(), recovered.Time(); got.Tick != want.Tick || got.Elapsed != want.Elapsed {
+		t.Errorf("recovered Time at tick %d, %vs; want tick %d, %vs", got.Tick, got.Elapsed, want.Tick, want.Elapsed)
+	}
+	if got, _ := Resource[testConfig](recovered); got != *config {
+		t.Errorf("recovered config %+v, want %+v", got, *config)
+	}
+}
+
+func TestJournalToleratesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w, entities := populatedWorld(t)
+	journal, err := w.StartJournal(dir, JournalOptions{})
+	if err != nil {
+		t.Fatalf("StartJournal: %v", err)
+	}
+	w.AddComponent(entities[3], &components.Lung{Capacity: 2})
+	if err := journal.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	path := filepath.Join(dir, journalFile)
+	info, _ := os.Stat(path)
+	complete := info.Size()
+
+	w.AddComponent(entities[6], &components.Lung{Capacity: 7})
+	journal.Sync()
+	info, _ = os.Stat(path)
+
+	// Cut the last record in half, as a crash mid-write would.
+	if err := os.Truncate(path, complete+(info.Size()-complete)/2); err != nil {
+		t.Fatal(err)
+	}
+	recovered := NewWorld()
+	if err := recovered.Recover(dir); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if lung, ok := Get[*components.Lung](recovered, entities[3]); !ok || lung.Capacity != 2 {
+		t.Errorf("record before the torn one was not replayed")
+	}
+	if _, ok := Get[*components.Lung](recovered, entities[6]); ok {
+		t.Errorf("torn record was replayed")
+	}
+	journal.Close()
+}
+
+func TestJournalCheckpointInterval(t *testing.T) {
+	dir := t.TempDir()
+	w, entities := populatedWorld(t)
+	journal, err := w.StartJournal(dir, JournalOptions{CheckpointInterval: 2, SyncEveryTick: true})
+	if err != nil {
+		t.Fatalf("StartJournal: %v", err)
+	}
+	start := journal.gen
+	for i := 0; i < 5; i++ {
+		w.AddComponent(entities[3], &components.Lung{Volume: float32(i)})
+		w.Update(0.1)
+	}
+	if journal.gen != start+2 {
+		t.Errorf("%d checkpoints in 5 ticks, want 2", journal.gen-start)
+	}
+
+	recovered := NewWorld()
+	if err := recovered.Recover(dir); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	requireSameEntities(t, w, recovered)
+	journal.Close()
+}
+
+func TestJournalFromOlderGenerationIsSkipped(t *testing.T) {
+	dir := t.TempDir()
+	w, entities := populatedWorld(t)
+	journal, err := w.StartJournal(dir, JournalOptions{})
+	if err != nil {
+		t.Fatalf("StartJournal: %v", err)
+	}
+	w.DestroyEntity(entities[0])
+	journal.Sync()
+	stale, _ := os.ReadFile(filepath.Join(dir, journalFile))
+
+	// A crash after the next checkpoint was written but before its journal
+	// replaced the old one.
+	if err := journal.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	journal.Close()
+	os.WriteFile(filepath.Join(dir, journalFile), stale, 0o644)
+
+	recovered := NewWorld()
+	if err := recovered.Recover(dir); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	requireSameEntities(t, w, recovered)
+}
@@ -0,0 +1,449 @@
+// internal/ecs/query.go
+
+package ecs
+
+import (
+	"reflect"
+	"sync"
+)
+
+// QueryBuilder collects the terms of a Query:
+//
+//   - With: the entity must have every listed type; its components are fetched.
+//   - Without: the entity must have none of the listed types.
+//   - Optional: fetched when present, nil otherwise; never affects matching.
+//   - AnyOf: the entity must have at least one of the listed types. Each call
+//     adds a separate group, and an empty group would match nothing, so
+//     Build rejects it.
+//   - Added, Changed: the entity must have the listed types, and they must
+//     have been added (or changed) since the query last ran. These terms do
+//     not fetch; see change_detection.go.
+//
+// Fetched components are yielded in the order With types were given followed
+// by Optional types.
+type QueryBuilder struct {
+	em       *EntityManager
+	with     []reflect.Type
+	without  []reflect.Type
+	optional []reflect.Type
+	anyOf    [][]reflect.Type
+	added    []reflect.Type
+	changed  []reflect.Type
+}
+
+// NewQuery starts a query over the manager's archetypes.
+func (em *EntityManager) NewQuery() *QueryBuilder {
+	return &QueryBuilder{em: em}
+}
+
+// NewQuery starts a query over the world's archetypes.
+func (w *World) NewQuery() *QueryBuilder {
+	return w.entityManager.NewQuery()
+}
+
+func (b *QueryBuilder) With(componentTypes ...reflect.Type) *QueryBuilder {
+	b.with = append(b.with, componentTypes...)
+	return b
+}
+
+func (b *QueryBuilder) Without(componentTypes ...reflect.Type) *QueryBuilder {
+	b.without = append(b.without, componentTypes...)
+	return b
+}
+
+func (b *QueryBuilder) Optional(componentTypes ...reflect.Type) *QueryBuilder {
+	b.optional = append(b.optional, componentTypes...)
+	return b
+}
+
+func (b *QueryBuilder) AnyOf(componentTypes ...reflect.Type) *QueryBuilder {
+	b.anyOf = append(b.anyOf, componentTypes)
+	return b
+}
+
+func (b *QueryBuilder) Added(componentTypes ...reflect.Type) *QueryBuilder {
+	b.added = append(b.added, componentTypes...)
+	return b
+}
+
+func (b *QueryBuilder) Changed(componentTypes ...reflect.Type) *QueryBuilder {
+	b.changed = append(b.changed, componentTypes...)
+	return b
+}
+
+// Build compiles the terms into component masks and registers the query with
+// the manager. Component types the manager has not seen yet are registered
+// so the masks stay valid for archetypes created later. It panics on an
+// AnyOf term without types.
+func (b *QueryBuilder) Build() *Query {
+	for _, group := range b.anyOf {
+		if len(group) == 0 {
+			panic("ecs: AnyOf needs at least one component type")
+		}
+	}
+
+	em := b.em
+	em.lock()
+	defer em.mu.Unlock()
+
+	q := &Query{
+		em:      em,
+		fetch:   make([]reflect.Type, 0, len(b.with)+len(b.optional)),
+		added:   b.added,
+		changed: b.changed,
+	}
+	q.fetch = append(q.fetch, b.with...)
+	q.fetch = append(q.fetch, b.optional...)
+	q.fetched = make([]Component, len(q.fetch))
+	for _, t := range b.with {
+		q.with.set(em.componentID(t))
+	}
+	for _, t := range b.added {
+		q.with.set(em.componentID(t))
+	}
+	for _, t := range b.changed {
+		q.with.set(em.componentID(t))
+	}
+	for _, t := range b.without {
+		q.without.set(em.componentID(t))
+	}
+	for _, group := range b.anyOf {
+		var mask componentMask
+		for _, t := range group {
+			mask.set(em.componentID(t))
+		}
+		q.anyOf = append(q.anyOf, mask)
+	}
+
+	for _, archetype := range em.archetypes {
+		q.consider(archetype)
+	}
+	em.queries = append(em.queries, q)
+	return q
+}
+
+// queryMatch is an archetype matched by a query together with the column of
+// each fetched type, -1 for absent Optional types, and of each Added and
+// Changed term.
+type queryMatch struct {
+	archetype      *Archetype
+	columns        []int
+	addedColumns   []int
+	changedColumns []int
+}
+
+// passes applies the Added and Changed terms to a row.
+func (m *queryMatch) passes(row int, lastRun uint64) bool {
+	for _, column := range m.addedColumns {
+		if m.archetype.ticks[column][row].added <= lastRun {
+			return false
+		}
+	}
+	for _, column := range m.changedColumns {
+		if m.archetype.ticks[column][row].changed <= lastRun {
+			return false
+		}
+	}
+	return true
+}
+
+// Query is a compiled, registered set of terms. It caches the archetypes it
+// matches and is told about every archetype created afterwards, so running it
+// never rescans the archetype list.
+type Query struct {
+	em      *EntityManager
+	fetch   []reflect.Type
+	with    componentMask
+	without componentMask
+	anyOf   []componentMask
+	matched []queryMatch
+
+	// Change detection state, only used when there are Added or Changed
+	// terms. Rows stamped after lastRun pass; runTick is the stamp the
+	// current run started at.
+	added   []reflect.Type
+	changed []reflect.Type
+	lastRun uint64
+	runTick uint64
+
+	// fetched is the scratch slice handed to Each callbacks.
+	fetched []Component
+	eachMu  sync.Mutex
+}
+
+func (q *Query) matches(archetype *Archetype) bool {
+	if !archetype.mask.containsAll(q.with) || archetype.mask.intersects(q.without) {
+		return false
+	}
+	for _, group := range q.anyOf {
+		if !archetype.mask.intersects(group) {
+			return false
+		}
+	}
+	return true
+}
+
+// consider caches the archetype if it matches. Callers must hold the write
+// lock.
+func (q *Query) consider(archetype *Archetype) {
+	if !q.matches(archetype) {
+		return
+	}
+	match := queryMatch{archetype: archetype, columns: make([]int, len(q.fetch))}
+	for i, t := range q.fetch {
+		match.columns[i] = archetype.column(t)
+	}
+	for _, t := range q.added {
+		match.addedColumns = append(match.addedColumns, archetype.column(t))
+	}
+	for _, t := range q.changed {
+		match.changedColumns = append(match.changedColumns, archetype.column(t))
+	}
+	q.matched = append(q.matched, match)
+}
+
+func (q *Query) tracksChanges() bool {
+	return len(q.added) > 0 || len(q.changed) > 0
+}
+
+// Release unregisters the query. It must not be used afterwards.
+func (q *Query) Release() {
+	em := q.em
+	em.lock()
+	defer em.mu.Unlock()
+
+	for i, other := range em.queries {
+		if other == q {
+			em.queries = append(em.queries[:i], em.queries[i+1:]...)
+			break
+		}
+	}
+	q.matched = nil
+}
+
+// Iter starts an iteration; see QueryIter for the locking rules. For queries
+// with Added or Changed terms each iteration counts as a run, and writes made
+// from here on are reported by the next run.
+func (q *Query) Iter() QueryIter {
+	q.em.mu.RLock()
+	if q.em.accessCheck.Load() != nil {
+		for _, t := range q.fetch {
+			q.em.checkAccess(t, false)
+		}
+		for _, t := range q.added {
+			q.em.checkAccess(t, false)
+		}
+		for _, t := range q.changed {
+			q.em.checkAccess(t, false)
+		}
+	}
+	if q.tracksChanges() {
+		q.runTick = q.em.changeTick.Add(1)
+	}
+	return QueryIter{query: q, match: -1, locked: true}
+}
+
+// Each calls fn for every matching entity with its fetched components. The
+// slice is reused between calls and must not be retained.
+func (q *Query) Each(fn func(entity Entity, components []Component)) {
+	fetched := q.fetched
+	if q.eachMu.TryLock() {
+		defer q.eachMu.Unlock()
+	} else {
+		// Nested or concurrent Each on the same query gets its own buffer.
+		fetched = make([]Component, len(q.fetch))
+	}
+
+	it := q.Iter()
+	defer it.Close()
+	for it.Next() {
+		for i := range fetched {
+			fetched[i] = it.Component(i)
+		}
+		fn(it.Entity(), fetched)
+	}
+}
+
+// Entities returns every matching entity.
+func (q *Query) Entities() []Entity {
+	var result []Entity
+	it := q.Iter()
+	for it.Next() {
+		result = append(result, it.Entity())
+	}
+	return result
+}
+
+// Contains reports whether the entity is alive and its archetype matches the
+// query. Added and Changed terms are not checked.
+func (q *Query) Contains(entity Entity) bool {
+	q.em.mu.RLock()
+	defer q.em.mu.RUnlock()
+
+	record := q.em.record(entity)
+	return record != nil && q.matches(record.archetype)
+}
+
+// QueryIter walks the rows of every archetype matched by a query. It holds
+// the EntityManager read lock from creation until Next returns false or Close
+// is called, so structural changes must not be made while iterating.
+type QueryIter struct {
+	query  *Query
+	match  int
+	row    int
+	locked bool
+}
+
+// Next advances to the next matching entity.
+func (it *QueryIter) Next() bool {
+	if !it.locked {
+		return false
+	}
+
+	q := it.query
+	for {
+		it.row++
+		for it.match < 0 || it.row >= len(q.matched[it.match].archetype.entities) {
+			it.match++
+			if it.match >= len(q.matched) {
+				it.Close()
+				return false
+			}
+			it.row = 0
+		}
+		if !q.tracksChanges() || q.matched[it.match].passes(it.row, q.lastRun) {
+			return true
+		}
+	}
+}
+
+// Entity returns the current entity.
+func (it *QueryIter) Entity() Entity {
+	return it.query.matched[it.match].archetype.entities[it.row]
+}
+
+// Component returns the current entity's i-th fetched component, or nil for
+// an absent Optional component.
+func (it *QueryIter) Component(i int) Component {
+	match := &it.query.matched[it.match]
+	column := match.columns[i]
+	if column < 0 {
+		return nil
+	}
+	return match.archetype.components[column][it.row]
+}
+
+// Close releases the read lock and ends the run. It is safe to call more
+// than once.
+func (it *QueryIter) Close() {
+	if it.locked {
+		it.locked = false
+		if it.query.tracksChanges() {
+			it.query.lastRun = it.query.runTick - 1
+		}
+		it.query.em.mu.RUnlock()
+	}
+}
+
+func (a *Archetype) hasAll(componentTypes []reflect.Type) bool {
+	for _, t := range componentTypes {
+		if a.column(t) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Query1 iterates every entity that has an A.
+type Query1[A Component] struct {
+	query *Query
+}
+
+func NewQuery1[A Component](w *World) *Query1[A] {
+	return &Query1[A]{w.NewQuery().With(TypeOf[A]()).Build()}
+}
+
+// Iter starts an iteration; see QueryIter for the locking rules.
+func (q *Query1[A]) Iter() Query1Iter[A] {
+	return Query1Iter[A]{q.query.Iter()}
+}
+
+// Each calls fn for every matching entity.
+func (q *Query1[A]) Each(fn func(Entity, A)) {
+	it := q.Iter()
+	defer it.Close()
+	for it.Next() {
+		fn(it.Get())
+	}
+}
+
+type Query1Iter[A Component] struct {
+	QueryIter
+}
+
+func (it *Query1Iter[A]) Get() (Entity, A) {
+	return it.Entity(), it.Component(0).(A)
+}
+
+// Query2 iterates every entity that has both an A and a B, yielding the
+// components that belong to that entity.
+type Query2[A, B Component] struct {
+	query *Query
+}
+
+func NewQuery2[A, B Component](w *World) *Query2[A, B] {
+	return &Query2[A, B]{w.NewQuery().With(TypeOf[A](), TypeOf[B]()).Build()}
+}
+
+// Iter starts an iteration; see QueryIter for the locking rules.
+func (q *Query2[A, B]) Iter() Query2Iter[A, B] {
+	return Query2Iter[A, B]{q.query.Iter()}
+}
+
+// Each calls fn for every matching entity.
+func (q *Query2[A, B]) Each(fn func(Entity, A, B)) {
+	it := q.Iter()
+	defer it.Close()
+	for it.Next() {
+		fn(it.Get())
+	}
+}
+
+type Query2Iter[A, B Component] struct {
+	QueryIter
+}
+
+func (it *Query2Iter[A, B]) Get() (Entity, A, B) {
+	return it.Entity(), it.Component(0).(A), it.Component(1).(B)
+}
+
+// Query3 iterates every entity that has an A, a B and a C.
+type Query3[A, B, C Component] struct {
+	query *Query
+}
+
+func NewQuery3[A, B, C Component](w *World) *Query3[A, B, C] {
+	return &Query3[A, B, C]{w.NewQuery().With(TypeOf[A](), TypeOf[B](), TypeOf[C]()).Build()}
+}
+
+// Iter starts an iteration; see QueryIter for the locking rules.
+func (q *Query3[A, B, C]) Iter() Query3Iter[A, B, C] {
+	return Query3Iter[A, B, C]{q.query.Iter()}
+}
+
+// Each calls fn for every matching entity.
+func (q *Query3[A, B, C]) Each(fn func(Entity, A, B, C)) {
+	it := q.Iter()
+	defer it.Close()
+	for it.Next() {
+		fn(it.Get())
+	}
+}
+
+type Query3Iter[A, B, C Component] struct {
+	QueryIter
+}
+
+func (it *Query3Iter[A, B, C]) Get() (Entity, A, B, C) {
+	return it.Entity(), it.Component(0).(A), it.Component(1).(B), it.Component(2).(C)
+}
@@ -0,0 +1,211 @@
+package ecs
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+var (
+	lungType  = TypeOf[*components.Lung]()
+	mouthType = TypeOf[*components.Mouth]()
+	sighType  = TypeOf[*testSigh]()
+)
+
+// queryWorld returns a world with an entity for each combination of lung,
+// mouth and sigh, named after the components it has.
+func queryWorld() (w *World, bare, lung, mouth, sigh, lungMouth, all Entity) {
+	w = NewWorld()
+	bare, lung, mouth, sigh, lungMouth, all = w.CreateEntity(), w.CreateEntity(), w.CreateEntity(),
+		w.CreateEntity(), w.CreateEntity(), w.CreateEntity()
+	for _, entity := range []Entity{lung, lungMouth, all} {
+		w.AddComponent(entity, &components.Lung{Capacity: float32(entity)})
+	}
+	for _, entity := range []Entity{mouth, lungMouth, all} {
+		w.AddComponent(entity, &components.Mouth{})
+	}
+	for _, entity := range []Entity{sigh, all} {
+		w.AddComponent(entity, &testSigh{})
+	}
+	return
+}
+
+func TestQueryWithout(t *testing.T) {
+	w, _, lung, _, _, lungMouth, _ := queryWorld()
+	requireRun(t, "With lung Without sigh", w.NewQuery().With(lungType).Without(sighType).Build(), lung, lungMouth)
+	requireRun(t, "With lung Without mouth, sigh", w.NewQuery().With(lungType).Without(mouthType, sighType).Build(), lung)
+
+	// Archetypes created after Build are matched too.
+	late := w.CreateEntity()
+	query := w.NewQuery().Without(mouthType, sighType).Build()
+	w.AddComponent(late, &components.Lung{})
+	w.AddComponent(late, &components.Mouth{})
+	if query.Contains(late) {
+		t.Error("Without query matched an entity that moved into an excluded archetype")
+	}
+}
+
+func TestQueryOptional(t *testing.T) {
+	w, _, _, mouth, _, lungMouth, all := queryWorld()
+	query := w.NewQuery().With(mouthType).Optional(lungType, sighType).Build()
+
+	seen := make(map[Entity][]Component)
+	query.Each(func(entity Entity, fetched []Component) {
+		seen[entity] = append([]Component(nil), fetched...)
+	})
+	if len(seen) != 3 {
+		t.Fatalf("Optional changed matching: yielded %d entities, want 3", len(seen))
+	}
+	for entity, lung := range map[Entity]bool{mouth: false, lungMouth: true, all: true} {
+		fetched := seen[entity]
+		if _, ok := fetched[0].(*components.Mouth); !ok {
+			t.Errorf("entity %d: first component %T, want the With type", entity, fetched[0])
+		}
+		if got, ok := fetched[1].(*components.Lung); lung != ok || lung && got.Capacity != float32(entity) {
+			t.Errorf("entity %d: optional lung = %v", entity, fetched[1])
+		}
+	}
+	if fetched := seen[mouth]; fetched[1] != nil || fetched[2] != nil {
+		t.Errorf("absent optional components = %v, want nil", fetched[1:])
+	}
+	if _, ok := seen[all][2].(*testSigh); !ok {
+		t.Errorf("present optional sigh = %v", seen[all][2])
+	}
+}
+
+func TestQueryAnyOf(t *testing.T) {
+	w, _, lung, mouth, sigh, lungMouth, all := queryWorld()
+	requireRun(t, "AnyOf lung, sigh", w.NewQuery().AnyOf(lungType, sighType).Build(), lung, sigh, lungMouth, all)
+	// Each AnyOf call is a separate group; all of them must match.
+	requireRun(t, "AnyOf lung, sigh AnyOf mouth",
+		w.NewQuery().AnyOf(lungType, sighType).AnyOf(mouthType).Build(), lungMouth, all)
+	requireRun(t, "AnyOf mouth, sigh Without lung",
+		w.NewQuery().AnyOf(mouthType, sighType).Without(lungType).Build(), mouth, sigh)
+}
+
+func TestQueryEmptyAnyOfPanics(t *testing.T) {
+	w := NewWorld()
+	defer func() {
+		if message, _ := recover().(string); !strings.Contains(message, "AnyOf") {
+			t.Fatalf("Build recovered %q, want an AnyOf error", message)
+		}
+	}()
+	w.NewQuery().With(lungType).AnyOf().Build()
+}
+
+func TestTypedQueries(t *testing.T) {
+	w, _, lung, _, _, lungMouth, all := queryWorld()
+
+	var got []Entity
+	NewQuery1[*components.Lung](w).Each(func(entity Entity, l *components.Lung) {
+		if l.Capacity != float32(entity) {
+			t.Errorf("Query1 gave entity %d a lung with capacity %v", entity, l.Capacity)
+		}
+		got = append(got, entity)
+	})
+	if want := []Entity{lung, lungMouth, all}; !sameEntities(got, want) {
+		t.Errorf("Query1 yielded %v, want %v", got, want)
+	}
+
+	got = nil
+	query2 := NewQuery2[*components.Lung, *components.Mouth](w)
+	it := query2.Iter()
+	for it.Next() {
+		entity, l, mouth := it.Get()
+		if l.Capacity != float32(entity) || mouth == nil {
+			t.Errorf("Query2 gave entity %d components %v, %v", entity, l, mouth)
+		}
+		got = append(got, entity)
+	}
+	if want := []Entity{lungMouth, all}; !sameEntities(got, want) {
+		t.Errorf("Query2 yielded %v, want %v", got, want)
+	}
+
+	got = nil
+	NewQuery3[*components.Lung, *components.Mouth, *testSigh](w).Each(func(entity Entity, l *components.Lung, _ *components.Mouth, sigh *testSigh) {
+		if l.Capacity != float32(entity) || sigh == nil {
+			t.Errorf("Query3 gave entity %d components %v, %v", entity, l, sigh)
+		}
+		got = append(got, entity)
+	})
+	if want := []Entity{all}; !sameEntities(got, want) {
+		t.Errorf("Query3 yielded %v, want %v", got, want)
+	}
+
+	// Closing an iteration early releases the read lock.
+	it = query2.Iter()
+	it.Next()
+	it.Close()
+	w.AddComponent(w.CreateEntity(), &components.Lung{})
+}
+
+// sameEntities reports whether got holds the entities of want, in any order.
+func sameEntities(got, want []Entity) bool {
+	sorted := append([]Entity(nil), got...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return reflect.DeepEqual(sorted, want)
+}
+
+func TestQueryMatchesArchetypesCreatedAfterBuild(t *testing.T) {
+	w := NewWorld()
+	query := NewQuery2[*components.Lung, *components.Mouth](w)
+	if len(query.query.matched) != 0 {
+		t.Fatalf("query matched %d archetypes of an empty world", len(query.query.matched))
+	}
+
+	// Each entity's components create a new archetype.
+	lungMouth, all := w.CreateEntity(), w.CreateEntity()
+	for _, entity := range []Entity{lungMouth, all} {
+		w.AddComponent(entity, &components.Lung{Capacity: float32(entity)})
+		w.AddComponent(entity, &components.Mouth{})
+	}
+	w.AddComponent(all, &testSigh{})
+	w.AddComponent(w.CreateEntity(), &components.Lung{})
+	if len(query.query.matched) != 2 {
+		t.Errorf("query cached %d archetypes, want 2", len(query.query.matched))
+	}
+
+	var got []Entity
+	query.Each(func(entity Entity, lung *components.Lung, _ *components.Mouth) {
+		if lung.Capacity != float32(entity) {
+			t.Errorf("entity %d got a lung with capacity %v", entity, lung.Capacity)
+		}
+		got = append(got, entity)
+	})
+	if want := []Entity{lungMouth, all}; !sameEntities(got, want) {
+		t.Errorf("query yielded %v, want %v", got, want)
+	}
+}
+
+func TestQueryIterationDoesNotAllocate(t *testing.T) {
+	w, _, _, _, _, _, _ := queryWorld()
+	query := w.NewQuery().With(lungType).Optional(mouthType).Build()
+	typed := NewQuery2[*components.Lung, *components.Mouth](w)
+
+	var volume float32
+	for name, iterate := range map[string]func(){
+		"Iter": func() {
+			it := query.Iter()
+			for it.Next() {
+				volume += it.Component(0).(*components.Lung).Volume
+			}
+		},
+		"Each": func() {
+			query.Each(func(_ Entity, fetched []Component) {
+				volume += fetched[0].(*components.Lung).Volume
+			})
+		},
+		"Query2.Each": func() {
+			typed.Each(func(_ Entity, lung *components.Lung, _ *components.Mouth) {
+				volume += lung.Volume
+			})
+		},
+	} {
+		if allocs := testing.AllocsPerRun(100, iterate); allocs != 0 {
+			t.Errorf("%s allocated %v times per iteration", name, allocs)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// internal/ecs/resources.go
+
+package ecs
+
+import (
+	"reflect"
+	"sync"
+)
+
+// resources holds world-wide singletons, one per type. Values are stored as
+// pointers so they can be updated in place.
+type resources struct {
+	values map[reflect.Type]any
+	mu     sync.RWMutex
+}
+
+func newResources() *resources {
+	return &resources{values: make(map[reflect.Type]any)}
+}
+
+// insert stores ptr, a pointer to the resource, under the pointed-to type.
+func (r *resources) insert(ptr any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[reflect.TypeOf(ptr).Elem()] = ptr
+}
+
+// get returns the pointer stored for the type, or nil.
+func (r *resources) get(resourceType reflect.Type) any {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.values[resourceType]
+}
+
+// byName returns the stored pointers keyed by resourceName.
+func (r *resources) byName() map[string]any {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make(map[string]any, len(r.values))
+	for t, ptr := range r.values {
+		result[resourceName(t)] = ptr
+	}
+	return result
+}
+
+// resourceName is the name a resource type is saved under. Named types are
+// qualified with their full package path, so types of the same name in
+// different packages do not collide.
+func resourceName(t reflect.Type) string {
+	if t.PkgPath() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// InsertResource stores a copy of resource as the world's singleton of its
+// type, replacing any previous one. Retrieve it with Resource or
+// ResourceMut, using the type that was passed here. LoadState and
+// ReadSnapshot only restore resources that have been inserted: saved
+// resources of any other type are ignored.
+func (w *World) InsertResource(resource any) {
+	if resource == nil {
+		panic("ecs: nil resource")
+	}
+	ptr := reflect.New(reflect.TypeOf(resource))
+	ptr.Elem().Set(reflect.ValueOf(resource))
+	w.resources.insert(ptr.Interface())
+}
+
+// Resource returns a copy of the world's T resource.
+func Resource[T any](w *World) (T, bool) {
+	w.entityManager.checkAccess(reflect.TypeOf((*T)(nil)).Elem(), false)
+	if ptr := resourcePtr[T](w); ptr != nil {
+		return *ptr, true
+	}
+	var zero T
+	return zero, false
+}
+
+// ResourceMut returns the world's T resource for in-place updates. Resources
+// are not locked: systems that write one should list its type in
+// Access.Writes, and readers in Access.Reads, so the scheduler keeps them
+// apart.
+func ResourceMut[T any](w *World) (*T, bool) {
+	w.entityManager.checkAccess(reflect.TypeOf((*T)(nil)).Elem(), true)
+	ptr := resourcePtr[T](w)
+	return ptr, ptr != nil
+}
+
+// resourcePtr returns the world's *T resource, or nil if there is none.
+func resourcePtr[T any](w *World) *T {
+	ptr, _ := w.resources.get(reflect.TypeOf((*T)(nil)).Elem()).(*T)
+	return ptr
+}
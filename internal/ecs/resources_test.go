@@ -0,0 +1,72 @@
+package ecs
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+func TestResourceAndResourceMut(t *testing.T) {
+	w := NewWorld()
+	if _, ok := Resource[testConfig](w); ok {
+		t.Fatal("Resource found a resource that was never inserted")
+	}
+	if ptr, ok := ResourceMut[testConfig](w); ok || ptr != nil {
+		t.Fatal("ResourceMut found a resource that was never inserted")
+	}
+
+	inserted := testConfig{Rate: 1, Label: "start"}
+	w.InsertResource(inserted)
+	inserted.Rate = 9 // InsertResource stored a copy
+
+	config, ok := Resource[testConfig](w)
+	if !ok || config != (testConfig{Rate: 1, Label: "start"}) {
+		t.Fatalf("Resource = %+v, %v", config, ok)
+	}
+	config.Label = "copy" // Resource returned a copy
+
+	ptr, ok := ResourceMut[testConfig](w)
+	if !ok || ptr.Label != "start" {
+		t.Fatalf("ResourceMut = %+v, %v", ptr, ok)
+	}
+	ptr.Rate = 2
+	if config, _ := Resource[testConfig](w); config.Rate != 2 {
+		t.Errorf("update through ResourceMut not visible: Rate %v", config.Rate)
+	}
+
+	// Resources are keyed by the exact type inserted.
+	if _, ok := Resource[*testConfig](w); ok {
+		t.Error("Resource[*testConfig] found the testConfig resource")
+	}
+}
+
+func TestResourceNamesIncludePackagePath(t *testing.T) {
+	for _, test := range []struct {
+		t    reflect.Type
+		want string
+	}{
+		{reflect.TypeOf(testConfig{}), "github.com/AMMPTT/strux/internal/ecs.testConfig"},
+		{reflect.TypeOf(components.Lung{}), "github.com/AMMPTT/strux/pkg/components.Lung"},
+		{reflect.TypeOf([]int{}), "[]int"},
+	} {
+		if got := resourceName(test.t); got != test.want {
+			t.Errorf("resourceName(%v) = %q, want %q", test.t, got, test.want)
+		}
+	}
+
+	w := NewWorld()
+	w.InsertResource(testConfig{Label: "saved"})
+	data, err := w.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	var saved struct{ Resources map[string]json.RawMessage }
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved.Resources["github.com/AMMPTT/strux/internal/ecs.testConfig"]; !ok {
+		t.Errorf("saved resources %v, want testConfig under its qualified name", saved.Resources)
+	}
+}
@@ -0,0 +1,423 @@
+// internal/ecs/scheduler.go
+
+package ecs
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Stage is a coarse phase of a frame. Every system of a stage finishes
+// before the next stage starts. StageFixedUpdate runs zero or more times per
+// frame under World.RunFrame; see fixed_time.go.
+type Stage int
+
+const (
+	StageFirst Stage = iota
+	StagePreUpdate
+	StageFixedUpdate
+	StageUpdate
+	StagePostUpdate
+	StageLast
+)
+
+var stageNames = [...]string{"First", "PreUpdate", "FixedUpdate", "Update", "PostUpdate", "Last"}
+
+func (s Stage) String() string {
+	if s >= 0 && int(s) < len(stageNames) {
+		return stageNames[s]
+	}
+	return fmt.Sprintf("Stage(%d)", int(s))
+}
+
+// SystemOption configures how a system is scheduled.
+type SystemOption func(*systemNode)
+
+// InStage places the system in a stage. Systems default to StageUpdate.
+func InStage(stage Stage) SystemOption {
+	return func(node *systemNode) { node.stage = stage }
+}
+
+// WithLabel names the system so other systems can order themselves against
+// it. Several systems may share a label. Every system is also labelled with
+// its type name, e.g. "*ecs.BreathingSystem".
+func WithLabel(labels ...string) SystemOption {
+	return func(node *systemNode) { node.labels = append(node.labels, labels...) }
+}
+
+// Before makes the system run before every system with the label.
+func Before(label string) SystemOption {
+	return func(node *systemNode) { node.before = append(node.before, label) }
+}
+
+// After makes the system run after every system with the label.
+func After(label string) SystemOption {
+	return func(node *systemNode) { node.after = append(node.after, label) }
+}
+
+// Scheduler runs systems stage by stage. Within a stage, Before/After
+// constraints are resolved into a topological order, ties broken by
+// registration order. Systems that conflict on declared component access
+// then run in that order, and everything else may run in parallel, so the
+// outcome of a frame does not depend on goroutine scheduling.
+type Scheduler struct {
+	world  *World
+	nodes  []*systemNode
+	stages [][]*systemNode // built schedule, indexed by Stage
+	dirty  bool
+
+	// In debug mode systems run one at a time and every component access
+	// made through the EntityManager is checked against the declaration.
+	debug      bool
+	violations []AccessViolation
+	seen       map[AccessViolation]bool
+	mu         sync.Mutex
+}
+
+type systemNode struct {
+	system    System
+	name      string
+	access    Access
+	exclusive bool
+
+	stage  Stage
+	labels []string
+	before []string
+	after  []string
+
+	order    int       // registration order
+	deps     []int     // positions within the stage that must finish first
+	commands *Commands // set for CommandSystems
+}
+
+func (n *systemNode) hasLabel(label string) bool {
+	for _, l := range n.labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// describe names the system in errors, with its explicit labels if any.
+func (n *systemNode) describe() string {
+	if len(n.labels) > 1 {
+		return fmt.Sprintf("%s[%s]", n.name, strings.Join(n.labels[1:], ","))
+	}
+	return n.name
+}
+
+// AccessViolation records a component access a system made without
+// declaring it.
+type AccessViolation struct {
+	System string
+	Type   reflect.Type
+	Write  bool
+}
+
+func (v AccessViolation) String() string {
+	kind := "read"
+	if v.Write {
+		kind = "write"
+	}
+	return fmt.Sprintf("%s: undeclared %s of %v", v.System, kind, v.Type)
+}
+
+func NewScheduler(w *World) *Scheduler {
+	return &Scheduler{world: w, dirty: true}
+}
+
+// Add registers a system. Access is read once, at registration.
+func (s *Scheduler) Add(system System, options ...SystemOption) {
+	node := &systemNode{
+		system:    system,
+		name:      fmt.Sprintf("%T", system),
+		exclusive: true,
+		stage:     StageUpdate,
+		order:     len(s.nodes),
+	}
+	node.labels = []string{node.name}
+	if declarer, ok := system.(AccessDeclarer); ok {
+		node.access = declarer.Access()
+		node.exclusive = node.access.Structural
+	}
+	if commandSystem, ok := system.(CommandSystem); ok {
+		node.commands = NewCommands(s.world)
+		commandSystem.SetCommands(node.commands)
+	}
+	for _, option := range options {
+		option(node)
+	}
+	s.nodes = append(s.nodes, node)
+	s.dirty = true
+}
+
+// SetDebug toggles access checking.
+func (s *Scheduler) SetDebug(debug bool) {
+	s.debug = debug
+}
+
+// Violations returns the access violations recorded in debug mode.
+func (s *Scheduler) Violations() []AccessViolation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AccessViolation(nil), s.violations...)
+}
+
+// Build resolves stages and ordering constraints. It reports unknown labels,
+// constraints that contradict stage order, and cycles.
+func (s *Scheduler) Build() error {
+	if !s.dirty {
+		return nil
+	}
+
+	// Every stage is kept, even empty ones, so each still gets a sync point.
+	stages := make([][]*systemNode, StageLast+1)
+	for _, node := range s.nodes {
+		if node.stage < 0 || node.stage > StageLast {
+			return fmt.Errorf("system %s: invalid stage %v", node.describe(), node.stage)
+		}
+		stages[node.stage] = append(stages[node.stage], node)
+	}
+
+	// edges[a] lists the systems that must run after a.
+	edges := make(map[*systemNode][]*systemNode)
+	link := func(first, then *systemNode, label string) error {
+		if first.stage > then.stage {
+			return fmt.Errorf("system %s must run after %s (label %q), but stage %v comes first",
+				then.describe(), first.describe(), label, then.stage)
+		}
+		if first.stage == then.stage {
+			edges[first] = append(edges[first], then)
+		}
+		return nil
+	}
+	for _, node := range s.nodes {
+		for _, label := range node.before {
+			targets := s.labelled(label)
+			if len(targets) == 0 {
+				return fmt.Errorf("system %s: Before(%q) matches no system", node.describe(), label)
+			}
+			for _, target := range targets {
+				if err := link(node, target, label); err != nil {
+					return err
+				}
+			}
+		}
+		for _, label := range node.after {
+			targets := s.labelled(label)
+			if len(targets) == 0 {
+				return fmt.Errorf("system %s: After(%q) matches no system", node.describe(), label)
+			}
+			for _, target := range targets {
+				if err := link(target, node, label); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for i, nodes := range stages {
+		ordered, err := topoSort(nodes, edges)
+		if err != nil {
+			return fmt.Errorf("stage %v: %w", Stage(i), err)
+		}
+		linkDependencies(ordered, edges)
+		stages[i] = ordered
+	}
+
+	s.stages = stages
+	s.dirty = false
+	return nil
+}
+
+func (s *Scheduler) labelled(label string) []*systemNode {
+	var result []*systemNode
+	for _, node := range s.nodes {
+		if node.hasLabel(label) {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// topoSort orders a stage with Kahn's algorithm, always picking the earliest
+// registered system among those that are ready.
+func topoSort(nodes []*systemNode, edges map[*systemNode][]*systemNode) ([]*systemNode, error) {
+	inDegree := make(map[*systemNode]int, len(nodes))
+	for _, node := range nodes {
+		for _, next := range edges[node] {
+			inDegree[next]++
+		}
+	}
+
+	var ready []*systemNode
+	for _, node := range nodes {
+		if inDegree[node] == 0 {
+			ready = append(ready, node)
+		}
+	}
+
+	ordered := make([]*systemNode, 0, len(nodes))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i].order < ready[j].order })
+		node := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, node)
+		for _, next := range edges[node] {
+			inDegree[next]--
+			if inDegree[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	if len(ordered) < len(nodes) {
+		var cycle []string
+		for _, node := range nodes {
+			if inDegree[node] > 0 {
+				cycle = append(cycle, node.describe())
+			}
+		}
+		return nil, fmt.Errorf("ordering cycle between %s", strings.Join(cycle, ", "))
+	}
+	return ordered, nil
+}
+
+// linkDependencies makes each system wait for the earlier systems in the
+// stage it is explicitly ordered after or conflicts with.
+func linkDependencies(ordered []*systemNode, edges map[*systemNode][]*systemNode) {
+	for j, node := range ordered {
+		node.deps = node.deps[:0]
+		for i := 0; i < j; i++ {
+			other := ordered[i]
+			explicit := false
+			for _, next := range edges[other] {
+				if next == node {
+					explicit = true
+					break
+				}
+			}
+			if explicit || node.exclusive || other.exclusive || node.access.conflicts(other.access) {
+				node.deps = append(node.deps, i)
+			}
+		}
+	}
+}
+
+// Run executes every system once, stage by stage, applying command buffers
+// at the end of each stage. It panics if the schedule cannot be built; call
+// Build first to handle that as an error.
+func (s *Scheduler) Run(dt float32) {
+	s.RunStages(StageFirst, StageLast, dt)
+}
+
+// RunStages executes the stages from first to last inclusive, like Run.
+func (s *Scheduler) RunStages(first, last Stage, dt float32) {
+	if err := s.Build(); err != nil {
+		panic(err)
+	}
+
+	em := s.world.entityManager
+	for stage := first; stage <= last; stage++ {
+		nodes := s.stages[stage]
+		s.runStage(em, nodes, dt)
+		s.syncPoint(nodes)
+	}
+}
+
+// syncPoint applies the stage's command buffers in schedule order, then the
+// world's buffer.
+func (s *Scheduler) syncPoint(nodes []*systemNode) {
+	for _, node := range nodes {
+		if node.commands != nil {
+			node.commands.Apply()
+		}
+	}
+	s.world.commands.Apply()
+}
+
+func (s *Scheduler) runStage(em *EntityManager, nodes []*systemNode, dt float32) {
+	if s.debug {
+		for _, node := range nodes {
+			s.runChecked(em, node, dt)
+		}
+		return
+	}
+
+	done := make([]chan struct{}, len(nodes))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	// A panicking system is re-panicked on the caller's goroutine once the
+	// stage has finished, so the panic unwinds through World.Update to its
+	// caller, who may recover it, instead of killing the process from a
+	// system's goroutine.
+	var panicked any
+	var panicOnce sync.Once
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *systemNode) {
+			defer wg.Done()
+			defer close(done[i])
+			defer func() {
+				if value := recover(); value != nil {
+					panicOnce.Do(func() { panicked = value })
+				}
+			}()
+			for _, dep := range node.deps {
+				<-done[dep]
+			}
+			s.update(em, node, dt)
+		}(i, node)
+	}
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+}
+
+// update runs one system, counting it among the systems running in parallel
+// unless it is exclusive.
+func (s *Scheduler) update(em *EntityManager, node *systemNode, dt float32) {
+	if !node.exclusive {
+		em.parallel.Add(1)
+		defer em.parallel.Add(-1)
+	}
+	node.system.Update(dt)
+}
+
+func (s *Scheduler) runChecked(em *EntityManager, node *systemNode, dt float32) {
+	if !node.exclusive {
+		em.setAccessCheck(func(componentType reflect.Type, write bool) {
+			allowed := node.access.canRead(componentType)
+			if write {
+				allowed = node.access.canWrite(componentType)
+			}
+			if !allowed {
+				s.report(AccessViolation{System: node.name, Type: componentType, Write: write})
+			}
+		})
+		defer em.setAccessCheck(nil)
+	}
+	s.update(em, node, dt)
+}
+
+// report records a violation once, however often it recurs.
+func (s *Scheduler) report(violation AccessViolation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seen == nil {
+		s.seen = make(map[AccessViolation]bool)
+	}
+	if !s.seen[violation] {
+		s.seen[violation] = true
+		s.violations = append(s.violations, violation)
+	}
+}
@@ -0,0 +1,229 @@
+package ecs
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+// funcSystem runs a function with the declared access.
+type funcSystem struct {
+	access Access
+	update func()
+}
+
+func (s *funcSystem) Update(float32) { s.update() }
+func (s *funcSystem) Access() Access { return s.access }
+
+// exclusiveSystem declares no access.
+type exclusiveSystem struct {
+	update func()
+}
+
+func (s *exclusiveSystem) Update(float32) { s.update() }
+
+var (
+	lungs  = []reflect.Type{TypeOf[*components.Lung]()}
+	mouths = []reflect.Type{TypeOf[*components.Mouth]()}
+)
+
+func TestDisjointSystemsRunInParallel(t *testing.T) {
+	w := NewWorld()
+	a, b := make(chan struct{}), make(chan struct{})
+	// Each system waits for the other to start, which only works if they run
+	// at the same time.
+	meet := func(mine, other chan struct{}) func() {
+		return func() {
+			close(mine)
+			select {
+			case <-other:
+			case <-time.After(time.Second):
+				t.Error("systems with disjoint access did not run in parallel")
+			}
+		}
+	}
+	w.AddSystem(&funcSystem{access: Access{Writes: lungs}, update: meet(a, b)})
+	w.AddSystem(&funcSystem{access: Access{Writes: mouths}, update: meet(b, a)})
+	w.Update(0.1)
+}
+
+func TestConflictingSystemsRunInRegistrationOrder(t *testing.T) {
+	w := NewWorld()
+	var running, overlaps atomic.Int32
+	var mu sync.Mutex
+	var order []int
+	track := func(i int) func() {
+		return func() {
+			if running.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			running.Add(-1)
+		}
+	}
+	w.AddSystem(&funcSystem{access: Access{Writes: lungs}, update: track(0)})
+	w.AddSystem(&funcSystem{access: Access{Reads: lungs}, update: track(1)})
+	w.AddSystem(&exclusiveSystem{update: track(2)})
+	w.AddSystem(&funcSystem{access: Access{Writes: lungs}, update: track(3)})
+
+	for i := 0; i < 5; i++ {
+		order = order[:0]
+		w.Update(0.1)
+		if overlaps.Load() != 0 {
+			t.Fatal("conflicting systems overlapped")
+		}
+		if want := []int{0, 1, 2, 3}; !reflect.DeepEqual(order, want) {
+			t.Fatalf("run order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestDebugModeReportsUndeclaredAccess(t *testing.T) {
+	w := NewWorld()
+	entity := w.CreateEntity()
+	w.AddComponent(entity, &components.Lung{})
+	w.AddComponent(entity, &components.Mouth{})
+	w.SetDebug(true)
+	w.AddSystem(&funcSystem{access: Access{Reads: lungs}, update: func() {
+		Get[*components.Lung](w, entity)
+		GetMut[*components.Lung](w, entity)
+		Get[*components.Mouth](w, entity)
+	}})
+	w.Update(0.1)
+	w.Update(0.1)
+
+	want := []AccessViolation{
+		{System: "*ecs.funcSystem", Type: lungs[0], Write: true},
+		{System: "*ecs.funcSystem", Type: mouths[0], Write: false},
+	}
+	if got := w.AccessViolations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("violations %v, want %v", got, want)
+	}
+}
+
+// iterateAndSpawn registers a system that reads lungs inside a query
+// iteration and a parallel one that creates entities directly, a pattern
+// that used to deadlock.
+func iterateAndSpawn(w *World, spawner Access) {
+	query := NewQuery1[*components.Lung](w)
+	w.AddSystem(&funcSystem{access: Access{Reads: lungs}, update: func() {
+		query.Each(func(entity Entity, _ *components.Lung) {
+			time.Sleep(time.Millisecond)
+			Has[*components.Lung](w, entity)
+		})
+	}})
+	w.AddSystem(&funcSystem{access: spawner, update: func() {
+		w.AddComponent(w.CreateEntity(), &components.Mouth{})
+	}})
+}
+
+func TestStructuralChangeFromParallelSystemPanics(t *testing.T) {
+	w, _ := populatedWorld(t)
+	iterateAndSpawn(w, Access{Writes: mouths})
+
+	defer func() {
+		value := recover()
+		if message, _ := value.(string); !strings.Contains(message, "Access.Structural") {
+			t.Fatalf("Update panicked with %v, want a structural change error", value)
+		}
+	}()
+	w.Update(0.1)
+}
+
+func TestStructuralSystemRunsExclusively(t *testing.T) {
+	w, _ := populatedWorld(t)
+	iterateAndSpawn(w, Access{Writes: mouths, Structural: true})
+	before := len(w.Query(mouths[0]))
+	w.Update(0.1)
+	if got := len(w.Query(mouths[0])); got != before+1 {
+		t.Errorf("%d mouths after the update, want %d", got, before+1)
+	}
+}
+
+// orderRecorder appends its name to a shared log when it runs. It declares
+// no access, so systems of a stage run one at a time.
+type orderRecorder struct {
+	name string
+	log  *[]string
+}
+
+func (r *orderRecorder) Update(float32) { *r.log = append(*r.log, r.name) }
+
+func TestBeforeAndAfterOrderSystems(t *testing.T) {
+	w := NewWorld()
+	var log []string
+	add := func(name string, options ...SystemOption) {
+		w.AddSystem(&orderRecorder{name: name, log: &log}, append(options, WithLabel(name))...)
+	}
+	add("render", After("physics"))
+	add("input", Before("physics"))
+	add("audio")
+	add("physics")
+	add("setup", InStage(StagePreUpdate), Before("input"))
+
+	if err := w.BuildSchedule(); err != nil {
+		t.Fatalf("BuildSchedule: %v", err)
+	}
+	w.Update(0.1)
+	// Ties go to the earlier registered system.
+	if want := []string{"setup", "input", "audio", "physics", "render"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("run order %v, want %v", log, want)
+	}
+}
+
+func TestBuildScheduleErrors(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		systems [][]SystemOption
+		want    string
+	}{
+		{
+			name:    "unknown label",
+			systems: [][]SystemOption{{After("missing")}},
+			want:    `After("missing") matches no system`,
+		},
+		{
+			name: "constraint against stage order",
+			systems: [][]SystemOption{
+				{WithLabel("early"), InStage(StagePreUpdate)},
+				{InStage(StageUpdate), Before("early")},
+			},
+			want: `must run after *ecs.orderRecorder (label "early"), but stage PreUpdate comes first`,
+		},
+		{
+			name: "cycle",
+			systems: [][]SystemOption{
+				{WithLabel("a"), After("c")},
+				{WithLabel("b"), After("a")},
+				{WithLabel("c"), After("b")},
+				{WithLabel("d")},
+			},
+			want: "stage Update: ordering cycle between *ecs.orderRecorder[a], *ecs.orderRecorder[b], *ecs.orderRecorder[c]",
+		},
+		{
+			name:    "invalid stage",
+			systems: [][]SystemOption{{InStage(StageLast + 1)}},
+			want:    "invalid stage Stage(6)",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := NewWorld()
+			var log []string
+			for _, options := range test.systems {
+				w.AddSystem(&orderRecorder{log: &log}, options...)
+			}
+			err := w.BuildSchedule()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("BuildSchedule error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,668 @@
+// internal/ecs/snapshot.go
+
+package ecs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+// A snapshot is a compact binary encoding of a world, written in one pass to
+// an io.Writer. Integers are little endian; counts and integer fields are
+// varints. Sections, in order:
+//
+//	header     magic "STRX", uint16 format version
+//	world      uint64 tick, allocator versions and free list
+//	type table per component type: registered name, schema version and
+//	           field descriptors (name, reflect.Kind), so a reader can decode
+//	           and skip fields without knowing the type
+//	columns    per type: row count, then every row's value of the first
+//	           field, then of the second field, and so on
+//	entities   per archetype: type table indices, then the entity handles;
+//	           the n-th entity holding a type owns that type's n-th row
+//	resources  name and JSON encoding of each resource
+//
+// Components must be pointers to structs whose exported fields are booleans,
+// numbers or strings. Fields are matched by name when reading, so fields
+// added to a type since the snapshot was written keep their zero value and
+// removed fields are skipped. Types saved at an older schema version are
+// decoded into field maps and upgraded by their registered migrations.
+const (
+	snapshotMagic   = "STRX"
+	snapshotVersion = 2 // 1 had no schema versions in the type table
+
+	// Limits that keep a corrupt snapshot from causing huge allocations.
+	maxSnapshotTypes  = 1 << 16
+	maxSnapshotString = 1 << 20
+)
+
+var errSnapshotLimit = errors.New("snapshot count exceeds limit")
+
+type fieldDescriptor struct {
+	name  string
+	kind  reflect.Kind
+	index int // field index in the current struct type, -1 if it no longer exists
+}
+
+type typeDescriptor struct {
+	name    string
+	version int          // schema version
+	elem    reflect.Type // the struct type components point to
+	fields  []fieldDescriptor
+}
+
+func snapshotKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// describeType builds the descriptor of a component type's current layout.
+func describeType(name string, componentType reflect.Type) (*typeDescriptor, error) {
+	if componentType.Kind() != reflect.Pointer || componentType.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("component %s: snapshots need a pointer to a struct, got %v", name, componentType)
+	}
+	elem := componentType.Elem()
+	descriptor := &typeDescriptor{name: name, elem: elem}
+	descriptor.version, _ = components.Version(name)
+	for i := 0; i < elem.NumField(); i++ {
+		field := elem.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if !snapshotKind(field.Type.Kind()) {
+			return nil, fmt.Errorf("component %s: field %s has unsupported type %v", name, field.Name, field.Type)
+		}
+		descriptor.fields = append(descriptor.fields, fieldDescriptor{name: field.Name, kind: field.Type.Kind(), index: i})
+	}
+	return descriptor, nil
+}
+
+// WriteSnapshot encodes the world's entities, components and resources to
+// out in the binary snapshot format. Every component type must be
+// registered with components.Register.
+func (w *World) WriteSnapshot(out io.Writer) error {
+	em := w.entityManager
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	return w.writeSnapshot(out)
+}
+
+// writeSnapshot is WriteSnapshot for callers holding the read lock.
+func (w *World) writeSnapshot(out io.Writer) error {
+	em := w.entityManager
+
+	// Type table, in order of first appearance.
+	var types []*typeDescriptor
+	typeIndex := make(map[reflect.Type]int)
+	for _, archetype := range em.archetypes {
+		for _, compType := range archetype.componentTypes {
+			if _, seen := typeIndex[compType]; seen {
+				continue
+			}
+			name, registered := components.TypeName(compType)
+			if !registered {
+				return fmt.Errorf("component type %v is not registered", compType)
+			}
+			descriptor, err := describeType(name, compType)
+			if err != nil {
+				return err
+			}
+			typeIndex[compType] = len(types)
+			types = append(types, descriptor)
+		}
+	}
+	resources, err := w.encodeResources()
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(out)
+	sw := &snapshotWriter{w: buffered}
+	sw.w.WriteString(snapshotMagic)
+	sw.uint16(snapshotVersion)
+
+	sw.uint64(em.tick.Load())
+	sw.allocator(em.entities.state())
+	sw.typeTable(types)
+
+	for _, descriptor := range types {
+		compType := reflect.PointerTo(descriptor.elem)
+		rows := 0
+		for _, archetype := range em.archetypes {
+			if archetype.column(compType) >= 0 {
+				rows += archetype.Len()
+			}
+		}
+		sw.uvarint(uint64(rows))
+		for _, field := range descriptor.fields {
+			for _, archetype := range em.archetypes {
+				column := archetype.column(compType)
+				if column < 0 {
+					continue
+				}
+				for _, component := range archetype.components[column] {
+					sw.value(field.kind, reflect.ValueOf(component).Elem().Field(field.index))
+				}
+			}
+		}
+	}
+
+	var populated []*Archetype
+	for _, archetype := range em.archetypes {
+		if archetype.Len() > 0 {
+			populated = append(populated, archetype)
+		}
+	}
+	sw.uvarint(uint64(len(populated)))
+	for _, archetype := range populated {
+		sw.uvarint(uint64(len(archetype.componentTypes)))
+		for _, compType := range archetype.componentTypes {
+			sw.uvarint(uint64(typeIndex[compType]))
+		}
+		sw.uvarint(uint64(len(archetype.entities)))
+		for _, entity := range archetype.entities {
+			sw.uint32(uint32(entity))
+		}
+	}
+
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sw.uvarint(uint64(len(names)))
+	for _, name := range names {
+		sw.string(name)
+		sw.bytes(resources[name])
+	}
+
+	return buffered.Flush()
+}
+
+// ReadSnapshot replaces the world with a snapshot written by WriteSnapshot.
+// Like LoadState, it decodes the whole snapshot before touching the world.
+func (w *World) ReadSnapshot(in io.Reader) error {
+	sr := &snapshotReader{r: bufio.NewReader(in)}
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(sr.r, magic); err != nil || string(magic) != snapshotMagic {
+		return errors.New("not a snapshot")
+	}
+	format := sr.uint16()
+	if sr.err == nil && (format < 1 || format > snapshotVersion) {
+		return fmt.Errorf("unsupported snapshot version %d", format)
+	}
+
+	state := &loadedState{tick: sr.uint64()}
+	state.allocator = sr.allocator()
+	types, err := sr.typeTable(format)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]Component, len(types))
+	for i, descriptor := range types {
+		column, err := sr.column(descriptor)
+		if err != nil {
+			return err
+		}
+		rows[i] = column
+	}
+
+	cursors := make([]int, len(types))
+	archetypes := sr.count(IndexMask + 1)
+	for a := 0; a < archetypes && sr.err == nil; a++ {
+		typeIDs := make([]int, sr.count(len(types)))
+		for i := range typeIDs {
+			typeIDs[i] = int(sr.uvarint())
+			if typeIDs[i] >= len(types) {
+				return fmt.Errorf("snapshot names component type %d of %d", typeIDs[i], len(types))
+			}
+		}
+		for n := sr.count(IndexMask + 1); n > 0 && sr.err == nil; n-- {
+			state.entities = append(state.entities, Entity(sr.uint32()))
+			owned := make([]Component, len(typeIDs))
+			for i, t := range typeIDs {
+				if cursors[t] >= len(rows[t]) {
+					return fmt.Errorf("snapshot has too few %s rows", types[t].name)
+				}
+				owned[i] = rows[t][cursors[t]]
+				cursors[t]++
+			}
+			state.owned = append(state.owned, owned)
+		}
+	}
+	for t, cursor := range cursors {
+		if sr.err == nil && cursor != len(rows[t]) {
+			return fmt.Errorf("snapshot has %d unowned %s rows", len(rows[t])-cursor, types[t].name)
+		}
+	}
+
+	state.resources = make(map[string]json.RawMessage)
+	for n := sr.count(maxSnapshotTypes); n > 0 && sr.err == nil; n-- {
+		name := sr.string()
+		state.resources[name] = sr.bytes()
+	}
+
+	if sr.err != nil {
+		return fmt.Errorf("reading snapshot: %w", sr.err)
+	}
+	return w.load(state)
+}
+
+// byteWriter is satisfied by *bufio.Writer and *bytes.Buffer.
+type byteWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// snapshotWriter encodes primitives. It ignores write errors: bufio.Writer
+// keeps the first one, which Flush reports, and bytes.Buffer has none.
+type snapshotWriter struct {
+	w   byteWriter
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) uvarint(v uint64) {
+	sw.w.Write(sw.buf[:binary.PutUvarint(sw.buf[:], v)])
+}
+
+func (sw *snapshotWriter) varint(v int64) {
+	sw.w.Write(sw.buf[:binary.PutVarint(sw.buf[:], v)])
+}
+
+func (sw *snapshotWriter) uint16(v uint16) {
+	binary.LittleEndian.PutUint16(sw.buf[:], v)
+	sw.w.Write(sw.buf[:2])
+}
+
+func (sw *snapshotWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(sw.buf[:], v)
+	sw.w.Write(sw.buf[:4])
+}
+
+func (sw *snapshotWriter) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(sw.buf[:], v)
+	sw.w.Write(sw.buf[:8])
+}
+
+func (sw *snapshotWriter) string(s string) {
+	sw.uvarint(uint64(len(s)))
+	sw.w.WriteString(s)
+}
+
+func (sw *snapshotWriter) bytes(b []byte) {
+	sw.uvarint(uint64(len(b)))
+	sw.w.Write(b)
+}
+
+func (sw *snapshotWriter) value(kind reflect.Kind, v reflect.Value) {
+	switch kind {
+	case reflect.Bool:
+		if v.Bool() {
+			sw.w.WriteByte(1)
+		} else {
+			sw.w.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sw.varint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		sw.uvarint(v.Uint())
+	case reflect.Float32:
+		sw.uint32(math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		sw.uint64(math.Float64bits(v.Float()))
+	case reflect.String:
+		sw.string(v.String())
+	}
+}
+
+func (sw *snapshotWriter) allocator(state allocatorState) {
+	sw.uvarint(uint64(len(state.Versions)))
+	sw.w.Write(state.Versions)
+	sw.uvarint(uint64(len(state.Free)))
+	for _, index := range state.Free {
+		sw.uvarint(uint64(index))
+	}
+}
+
+func (sw *snapshotWriter) typeTable(types []*typeDescriptor) {
+	sw.uvarint(uint64(len(types)))
+	for _, descriptor := range types {
+		sw.string(descriptor.name)
+		sw.uvarint(uint64(descriptor.version))
+		sw.uvarint(uint64(len(descriptor.fields)))
+		for _, field := range descriptor.fields {
+			sw.string(field.name)
+			sw.w.WriteByte(byte(field.kind))
+		}
+	}
+}
+
+// component encodes one component's fields in descriptor order.
+func (sw *snapshotWriter) component(descriptor *typeDescriptor, component Component) {
+	elem := reflect.ValueOf(component).Elem()
+	for _, field := range descriptor.fields {
+		sw.value(field.kind, elem.Field(field.index))
+	}
+}
+
+// snapshotReader decodes primitives, keeping the first error. After an error
+// every read returns a zero value.
+type snapshotReader struct {
+	r interface {
+		io.Reader
+		io.ByteReader
+	}
+	buf [8]byte
+	err error
+}
+
+func (sr *snapshotReader) fail(err error) {
+	if sr.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		sr.err = err
+	}
+}
+
+func (sr *snapshotReader) read(p []byte) {
+	if sr.err == nil {
+		if _, err := io.ReadFull(sr.r, p); err != nil {
+			sr.fail(err)
+		}
+	}
+}
+
+func (sr *snapshotReader) uvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(sr.r)
+	sr.fail(err)
+	return v
+}
+
+func (sr *snapshotReader) varint() int64 {
+	if sr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(sr.r)
+	sr.fail(err)
+	return v
+}
+
+// count reads a length and checks it against limit.
+func (sr *snapshotReader) count(limit int) int {
+	n := sr.uvarint()
+	if n > uint64(limit) {
+		sr.fail(errSnapshotLimit)
+		return 0
+	}
+	return int(n)
+}
+
+func (sr *snapshotReader) byte() byte {
+	sr.read(sr.buf[:1])
+	return sr.buf[0]
+}
+
+func (sr *snapshotReader) uint16() uint16 {
+	sr.read(sr.buf[:2])
+	return binary.LittleEndian.Uint16(sr.buf[:2])
+}
+
+func (sr *snapshotReader) uint32() uint32 {
+	sr.read(sr.buf[:4])
+	return binary.LittleEndian.Uint32(sr.buf[:4])
+}
+
+func (sr *snapshotReader) uint64() uint64 {
+	sr.read(sr.buf[:8])
+	return binary.LittleEndian.Uint64(sr.buf[:8])
+}
+
+func (sr *snapshotReader) bytes() []byte {
+	b := make([]byte, sr.count(maxSnapshotString))
+	sr.read(b)
+	return b
+}
+
+func (sr *snapshotReader) string() string {
+	return string(sr.bytes())
+}
+
+// value decodes one field value into dst, or discards it if dst is invalid.
+func (sr *snapshotReader) value(kind reflect.Kind, dst reflect.Value) {
+	switch kind {
+	case reflect.Bool:
+		v := sr.byte()
+		if dst.IsValid() {
+			dst.SetBool(v != 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v := sr.varint()
+		if dst.IsValid() {
+			dst.SetInt(v)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v := sr.uvarint()
+		if dst.IsValid() {
+			dst.SetUint(v)
+		}
+	case reflect.Float32:
+		v := math.Float32frombits(sr.uint32())
+		if dst.IsValid() {
+			dst.SetFloat(float64(v))
+		}
+	case reflect.Float64:
+		v := math.Float64frombits(sr.uint64())
+		if dst.IsValid() {
+			dst.SetFloat(v)
+		}
+	case reflect.String:
+		v := sr.string()
+		if dst.IsValid() {
+			dst.SetString(v)
+		}
+	}
+}
+
+// any decodes one field value the way encoding/json would decode it into an
+// interface: numbers become float64. Migrations work on values of this form.
+func (sr *snapshotReader) any(kind reflect.Kind) any {
+	switch kind {
+	case reflect.Bool:
+		return sr.byte() != 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(sr.varint())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(sr.uvarint())
+	case reflect.Float32:
+		return float64(math.Float32frombits(sr.uint32()))
+	case reflect.Float64:
+		return math.Float64frombits(sr.uint64())
+	case reflect.String:
+		return sr.string()
+	}
+	return nil
+}
+
+func (sr *snapshotReader) allocator() allocatorState {
+	var state allocatorState
+	state.Versions = make([]uint8, sr.count(IndexMask+1))
+	sr.read(state.Versions)
+	state.Free = make([]uint32, sr.count(len(state.Versions)))
+	for i := range state.Free {
+		state.Free[i] = uint32(sr.uvarint())
+	}
+	return state
+}
+
+func (sr *snapshotReader) typeTable(format uint16) ([]*typeDescriptor, error) {
+	types := make([]*typeDescriptor, sr.count(maxSnapshotTypes))
+	for i := range types {
+		descriptor, err := sr.typeDescriptor(format)
+		if err != nil {
+			return nil, err
+		}
+		types[i] = descriptor
+	}
+	return types, nil
+}
+
+// typeDescriptor reads a type table entry. Entries of the current schema
+// version have their fields matched by name against the registered type's
+// layout; older entries must have a migration path to the current version.
+func (sr *snapshotReader) typeDescriptor(format uint16) (*typeDescriptor, error) {
+	name := sr.string()
+	version := 1
+	if format >= 2 {
+		version = sr.count(math.MaxInt32)
+	}
+	fields := make([]fieldDescriptor, sr.count(maxSnapshotTypes))
+	for i := range fields {
+		fields[i].name = sr.string()
+		fields[i].kind = reflect.Kind(sr.byte())
+	}
+	if sr.err != nil {
+		return nil, fmt.Errorf("reading snapshot: %w", sr.err)
+	}
+
+	component, registered := components.New(name)
+	if !registered {
+		return nil, fmt.Errorf("snapshot has unknown component type %q", name)
+	}
+	current, err := describeType(name, reflect.TypeOf(component))
+	if err != nil {
+		return nil, err
+	}
+	if version != current.version {
+		if err := components.CheckMigration(name, version); err != nil {
+			return nil, err
+		}
+	}
+	for i := range fields {
+		field := &fields[i]
+		if !snapshotKind(field.kind) {
+			return nil, fmt.Errorf("component %s: field %s has unsupported kind %v", name, field.name, field.kind)
+		}
+		field.index = -1
+		if version != current.version {
+			continue // decoded through a migration instead
+		}
+		for _, c := range current.fields {
+			if c.name != field.name {
+				continue
+			}
+			if c.kind != field.kind {
+				return nil, fmt.Errorf("component %s: field %s was %v, is now %v", name, field.name, field.kind, c.kind)
+			}
+			field.index = c.index
+		}
+	}
+	current.version = version
+	current.fields = fields
+	return current, nil
+}
+
+// column reads a type's rows into new components, migrating them if they
+// were written at an older schema version.
+func (sr *snapshotReader) column(descriptor *typeDescriptor) ([]Component, error) {
+	rows := make([]Component, sr.count(IndexMask+1))
+	for i := range rows {
+		rows[i], _ = components.New(descriptor.name)
+	}
+
+	if current, _ := components.Version(descriptor.name); descriptor.version != current {
+		fields := make([]map[string]any, len(rows))
+		for i := range fields {
+			fields[i] = make(map[string]any, len(descriptor.fields))
+		}
+		for _, field := range descriptor.fields {
+			for i := 0; i < len(rows) && sr.err == nil; i++ {
+				fields[i][field.name] = sr.any(field.kind)
+			}
+		}
+		for i := 0; i < len(rows) && sr.err == nil; i++ {
+			if err := migrateInto(rows[i], descriptor, fields[i]); err != nil {
+				return nil, err
+			}
+		}
+		return rows, nil
+	}
+
+	elems := make([]reflect.Value, len(rows))
+	for i, component := range rows {
+		elems[i] = reflect.ValueOf(component).Elem()
+	}
+	for _, field := range descriptor.fields {
+		for i := 0; i < len(rows) && sr.err == nil; i++ {
+			var dst reflect.Value
+			if field.index >= 0 {
+				dst = elems[i].Field(field.index)
+			}
+			sr.value(field.kind, dst)
+		}
+	}
+	return rows, nil
+}
+
+// component reads one component written by snapshotWriter.component,
+// migrating it if it was written at an older schema version.
+func (sr *snapshotReader) component(descriptor *typeDescriptor) (Component, error) {
+	component, _ := components.New(descriptor.name)
+	if current, _ := components.Version(descriptor.name); descriptor.version != current {
+		fields := make(map[string]any, len(descriptor.fields))
+		for _, field := range descriptor.fields {
+			fields[field.name] = sr.any(field.kind)
+		}
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		return component, migrateInto(component, descriptor, fields)
+	}
+
+	elem := reflect.ValueOf(component).Elem()
+	for _, field := range descriptor.fields {
+		var dst reflect.Value
+		if field.index >= 0 {
+			dst = elem.Field(field.index)
+		}
+		sr.value(field.kind, dst)
+	}
+	return component, sr.err
+}
+
+// migrateInto upgrades saved fields to the current schema and decodes them
+// into component.
+func migrateInto(component Component, descriptor *typeDescriptor, fields map[string]any) error {
+	if err := components.Migrate(descriptor.name, descriptor.version, fields); err != nil {
+		return err
+	}
+	data, err := json.Marshal(fields)
+	if err == nil {
+		err = json.Unmarshal(data, component)
+	}
+	if err != nil {
+		return fmt.Errorf("component %s: %w", descriptor.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package ecs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	original, entities := populatedWorld(t)
+	var buf bytes.Buffer
+	if err := original.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	loaded := NewWorld()
+	loaded.InsertResource(testConfig{})
+	if err := loaded.ReadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+
+	// The JSON encoding of both worlds must match exactly.
+	want, _ := original.SaveState()
+	got, err := loaded.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("snapshot round trip changed the world:\n%s\n%s", want, got)
+	}
+	for _, entity := range entities {
+		if original.IsAlive(entity) != loaded.IsAlive(entity) {
+			t.Errorf("entity %d: alive %v after load", entity, loaded.IsAlive(entity))
+		}
+	}
+}
+
+func TestReadSnapshotRejectsTruncatedInput(t *testing.T) {
+	original, _ := populatedWorld(t)
+	var buf bytes.Buffer
+	if err := original.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	w := NewWorld()
+	entity := w.CreateEntity()
+	w.AddComponent(entity, &components.Mouth{IsOpen: true})
+
+	data := buf.Bytes()
+	for _, n := range []int{0, 3, len(snapshotMagic) + 2, len(data) / 2, len(data) - 1} {
+		if err := w.ReadSnapshot(bytes.NewReader(data[:n])); err == nil {
+			t.Fatalf("ReadSnapshot accepted %d of %d bytes", n, len(data))
+		}
+	}
+	if mouth, ok := Get[*components.Mouth](w, entity); !ok || !mouth.IsOpen {
+		t.Errorf("world changed by a failed read")
+	}
+}
+
+func TestReadSnapshotRejectsOtherVersions(t *testing.T) {
+	original, _ := populatedWorld(t)
+	var buf bytes.Buffer
+	if err := original.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+	data := buf.Bytes()
+	data[len(snapshotMagic)] = snapshotVersion + 1
+
+	err := NewWorld().ReadSnapshot(bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "version") {
+		t.Fatalf("ReadSnapshot error = %v, want unsupported version", err)
+	}
+}
+
+const benchmarkEntities = 200000
+
+// largeWorld mixes entities with a Lung, a Mouth, or both.
+func largeWorld(b *testing.B) *World {
+	b.Helper()
+
+	w := NewWorld()
+	for i := 0; i < benchmarkEntities; i++ {
+		entity := w.CreateEntity()
+		if i%3 != 0 {
+			w.AddComponent(entity, &components.Lung{State: components.LungState(i % 2), Capacity: 1, Volume: float32(i%100) / 100})
+		}
+		if i%3 != 1 {
+			w.AddComponent(entity, &components.Mouth{IsOpen: i%2 == 0})
+		}
+	}
+	return w
+}
+
+func BenchmarkSaveStateJSON(b *testing.B) {
+	w := largeWorld(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := w.SaveState()
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(int64(len(data)))
+	}
+}
+
+func BenchmarkWriteSnapshot(b *testing.B) {
+	w := largeWorld(b)
+	var buf bytes.Buffer
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := w.WriteSnapshot(&buf); err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(int64(buf.Len()))
+	}
+}
+
+func BenchmarkLoadStateJSON(b *testing.B) {
+	data, err := largeWorld(b).SaveState()
+	if err != nil {
+		b.Fatal(err)
+	}
+	w := NewWorld()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.LoadState(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadSnapshot(b *testing.B) {
+	var buf bytes.Buffer
+	if err := largeWorld(b).WriteSnapshot(&buf); err != nil {
+		b.Fatal(err)
+	}
+	w := NewWorld()
+	b.SetBytes(int64(buf.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.ReadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReadSnapshotMigratesOldVersions(t *testing.T) {
+	w := NewWorld()
+	entity := w.CreateEntity()
+	w.AddComponent(entity, &testBreath{Rate: 20, Depth: 9})
+	var buf bytes.Buffer
+	if err := w.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	// Rewrite the type table entry as if written at version 1.
+	name := "testBreath"
+	entry := func(version byte) []byte {
+		return append(append([]byte{byte(len(name))}, name...), version)
+	}
+	data := bytes.Replace(buf.Bytes(), entry(2), entry(1), 1)
+
+	loaded := NewWorld()
+	if err := loaded.ReadSnapshot(bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	if breath, ok := Get[*testBreath](loaded, entity); !ok || *breath != (testBreath{Rate: 20, Depth: 2}) {
+		t.Fatalf("migrated component = %+v, want Rate 20 and Depth 2", breath)
+	}
+}
@@ -1,5 +1,61 @@
 package ecs
 
+import (
+	"reflect"
+)
+
 type System interface {
 	Update(dt float32)
-}
\ No newline at end of file
+}
+
+// Access declares the component types a system reads and writes. A type in
+// Writes may also be read.
+//
+// Structural marks a system that creates or destroys entities, or adds or
+// removes components, directly instead of through Commands. It runs
+// exclusively; any other system that tries panics.
+type Access struct {
+	Reads      []reflect.Type
+	Writes     []reflect.Type
+	Structural bool
+}
+
+// AccessDeclarer is implemented by systems that declare their component
+// access. The scheduler runs systems with disjoint access in parallel;
+// systems that do not declare access, or declare structural access, run
+// exclusively.
+type AccessDeclarer interface {
+	Access() Access
+}
+
+// conflicts reports whether two systems may not run at the same time: one
+// writes a type the other reads or writes.
+func (a Access) conflicts(other Access) bool {
+	return writesAny(a.Writes, other.Reads) || writesAny(a.Writes, other.Writes) || writesAny(other.Writes, a.Reads)
+}
+
+func (a Access) canRead(componentType reflect.Type) bool {
+	return containsType(a.Reads, componentType) || containsType(a.Writes, componentType)
+}
+
+func (a Access) canWrite(componentType reflect.Type) bool {
+	return containsType(a.Writes, componentType)
+}
+
+func writesAny(writes, others []reflect.Type) bool {
+	for _, t := range writes {
+		if containsType(others, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsType(types []reflect.Type, componentType reflect.Type) bool {
+	for _, t := range types {
+		if t == componentType {
+			return true
+		}
+	}
+	return false
+}
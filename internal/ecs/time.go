@@ -0,0 +1,78 @@
+// internal/ecs/time.go
+
+package ecs
+
+// Time is the simulation clock, kept as a world resource. World.Update and
+// World.RunFrame consult it before running any system: while paused they do
+// nothing unless steps were queued with Step, and the frame time they are
+// given is multiplied by Scale. Systems read it through World.Time.
+//
+// The controls are not synchronized with Update; call them from the
+// goroutine that drives the world.
+type Time struct {
+	Elapsed float64 // scaled seconds simulated so far
+	Delta   float32 // scaled seconds of the current update
+	Tick    uint64  // world tick of the current update
+	Scale   float32 // multiplier applied to frame time; 1 is real time
+	Paused  bool
+
+	steps int // updates still to run while paused
+}
+
+// begin reports whether the next update should run, consuming a queued step
+// if the clock is paused.
+func (t *Time) begin() bool {
+	if !t.Paused {
+		return true
+	}
+	if t.steps > 0 {
+		t.steps--
+		return true
+	}
+	return false
+}
+
+func (t *Time) advance(delta float32, tick uint64) {
+	t.Delta = delta
+	t.Elapsed += float64(delta)
+	t.Tick = tick
+}
+
+// Time returns a copy of the simulation clock.
+func (w *World) Time() Time {
+	return *resourcePtr[Time](w)
+}
+
+// Pause stops Update and RunFrame from running systems. Queued steps are
+// dropped.
+func (w *World) Pause() {
+	clock := resourcePtr[Time](w)
+	clock.Paused = true
+	clock.steps = 0
+}
+
+// Resume undoes Pause.
+func (w *World) Resume() {
+	clock := resourcePtr[Time](w)
+	clock.Paused = false
+	clock.steps = 0
+}
+
+// SetTimeScale slows down (scale < 1) or speeds up (scale > 1) simulated
+// time relative to the frame time passed to Update and RunFrame.
+func (w *World) SetTimeScale(scale float32) {
+	if scale < 0 {
+		panic("ecs: time scale must not be negative")
+	}
+	resourcePtr[Time](w).Scale = scale
+}
+
+// Step lets a paused world run exactly n more ticks: each of the next n calls
+// to Update or RunFrame runs once, then the world is paused again. Under
+// RunFrame each step is exactly one fixed update. Step pauses the world if it
+// was running.
+func (w *World) Step(n int) {
+	clock := resourcePtr[Time](w)
+	clock.Paused = true
+	clock.steps += n
+}
@@ -0,0 +1,98 @@
+package ecs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPausedUpdateRunsOnlySteps(t *testing.T) {
+	w := NewWorld()
+	recorder := &dtRecorder{}
+	w.AddSystem(recorder)
+
+	w.Pause()
+	w.Update(0.5)
+	if len(recorder.dts) != 0 || w.Tick() != 0 {
+		t.Fatalf("paused Update ran systems")
+	}
+
+	w.Step(2)
+	for i := 0; i < 4; i++ {
+		w.Update(0.5)
+	}
+	if len(recorder.dts) != 2 || w.Tick() != 2 {
+		t.Errorf("Step(2) ran %d updates and %d ticks, want 2", len(recorder.dts), w.Tick())
+	}
+	if clock := w.Time(); !clock.Paused || clock.Elapsed != 1 {
+		t.Errorf("after stepping: Paused %v, Elapsed %v; want paused at 1s", clock.Paused, clock.Elapsed)
+	}
+
+	w.Resume()
+	w.Update(0.5)
+	if len(recorder.dts) != 3 {
+		t.Errorf("Update after Resume did not run")
+	}
+}
+
+func TestPausedRunFrameStepsOneFixedUpdate(t *testing.T) {
+	w := NewWorld()
+	w.SetFixedTimestep(0.25, 0)
+	fixed, variable := &dtRecorder{}, &dtRecorder{}
+	w.AddSystem(fixed, InStage(StageFixedUpdate))
+	w.AddSystem(variable, InStage(StageUpdate))
+
+	w.Pause()
+	if steps := w.RunFrame(1); steps != 0 {
+		t.Fatalf("paused RunFrame ran %d steps", steps)
+	}
+
+	// Each step is exactly one fixed update, whatever the frame time.
+	w.Step(3)
+	for i := 0; i < 5; i++ {
+		w.RunFrame(1)
+	}
+	if want := []float32{0.25, 0.25, 0.25}; !reflect.DeepEqual(fixed.dts, want) {
+		t.Errorf("fixed update dts %v, want %v", fixed.dts, want)
+	}
+	if want := []float32{0.25, 0.25, 0.25}; !reflect.DeepEqual(variable.dts, want) {
+		t.Errorf("update dts %v, want %v", variable.dts, want)
+	}
+	if w.Tick() != 3 {
+		t.Errorf("tick = %d, want 3", w.Tick())
+	}
+}
+
+func TestPauseDropsQueuedSteps(t *testing.T) {
+	w := NewWorld()
+	w.Step(5)
+	w.Pause()
+	w.Update(0.1)
+	if w.Tick() != 0 {
+		t.Errorf("Pause kept the queued steps")
+	}
+}
+
+func TestTimeScale(t *testing.T) {
+	w := NewWorld()
+	w.SetFixedTimestep(0.25, 0)
+	fixed, variable := &dtRecorder{}, &dtRecorder{}
+	w.AddSystem(fixed, InStage(StageFixedUpdate))
+	w.AddSystem(variable, InStage(StageUpdate))
+	w.SetTimeScale(0.5)
+
+	w.Update(1)
+	if clock := w.Time(); clock.Delta != 0.5 || clock.Elapsed != 0.5 {
+		t.Errorf("scaled Update: Delta %v, Elapsed %v; want 0.5", clock.Delta, clock.Elapsed)
+	}
+	if steps := w.RunFrame(1); steps != 2 {
+		t.Errorf("scaled RunFrame ran %d steps, want 2", steps)
+	}
+	if want := []float32{0.5, 0.5}; !reflect.DeepEqual(variable.dts, want) {
+		t.Errorf("update dts %v, want %v", variable.dts, want)
+	}
+
+	w.SetTimeScale(0)
+	if steps := w.RunFrame(1); steps != 0 || w.Time().Elapsed != 1 {
+		t.Errorf("RunFrame at scale 0 ran %d steps", steps)
+	}
+}
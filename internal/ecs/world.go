@@ -3,131 +3,377 @@ package ecs
 import (
     "encoding/json"
     "reflect"
-    "sync"
     "fmt"
     "github.com/AMMPTT/strux/pkg/components"
 )
 
+// World coordinates systems and events and delegates all entity and
+// component state to its EntityManager.
 type World struct {
-    entities      map[Entity]bool
-    components    map[reflect.Type]*ComponentArray
-    systems       []System
+    entityManager *EntityManager
+    scheduler     *Scheduler
+    commands      *Commands
+    resources     *resources
+    events        *eventQueues
+    journal       *Journal
     EventManager  *EventManager  // Changed to uppercase to export
-    mu            sync.RWMutex
 }
 
 func NewWorld() *World {
-    return &World{
-        entities:     make(map[Entity]bool),
-        components:   make(map[reflect.Type]*ComponentArray),
-        systems:      make([]System, 0),
-        EventManager: NewEventManager(),
+    w := &World{
+        entityManager: NewEntityManager(),
+        EventManager:  NewEventManager(),
+        resources:     newResources(),
+        events:        newEventQueues(),
     }
+    w.scheduler = NewScheduler(w)
+    w.commands = NewCommands(w)
+    w.resources.insert(&Time{Scale: 1})
+    w.resources.insert(&FixedTime{Step: DefaultFixedStep, MaxSteps: DefaultMaxFixedSteps})
+    return w
 }
 
+// EntityManager returns the storage backend of the world, for systems that
+// query archetypes directly.
+func (w *World) EntityManager() *EntityManager {
+    return w.entityManager
+}
 
-func (w *World) AddSystem(system System) {
-    w.systems = append(w.systems, system)
+// AddSystem registers a system, by default in StageUpdate. Options set its
+// stage, labels and Before/After constraints.
+func (w *World) AddSystem(system System, options ...SystemOption) {
+    w.scheduler.Add(system, options...)
     fmt.Println("Adding System!...", system)
 }
 
+// BuildSchedule resolves stages and ordering constraints, reporting cycles
+// and unknown labels. Update builds the schedule itself and panics on these
+// errors, so call BuildSchedule after adding systems to handle them.
+func (w *World) BuildSchedule() error {
+    return w.scheduler.Build()
+}
+
+// Update advances the world tick and runs every system once, stage by stage,
+// StageFixedUpdate included, all with the given dt. Within a stage, systems
+// that declare non-conflicting Access run in parallel. Use RunFrame to step
+// StageFixedUpdate at a fixed rate instead.
+//
+// dt is multiplied by the Time resource's scale, and nothing runs while the
+// world is paused unless a step was queued.
 func (w *World) Update(dt float32) {
-    var wg sync.WaitGroup
-    for _, system := range w.systems {
-        wg.Add(1)
-        go func(s System) {
-            defer wg.Done()
-            s.Update(dt)
-        }(system)
+    clock := resourcePtr[Time](w)
+    if !clock.begin() {
+        return
     }
-    wg.Wait()
+    dt *= clock.Scale
+    clock.advance(dt, w.entityManager.AdvanceTick())
+    w.events.swap()
+    w.scheduler.Run(dt)
+    if w.journal != nil {
+        w.journal.endTick()
+    }
+}
+
+// SetDebug toggles debug scheduling: systems run one at a time and their
+// component accesses are checked against the Access they declare.
+func (w *World) SetDebug(debug bool) {
+    w.scheduler.SetDebug(debug)
+}
+
+// AccessViolations returns the undeclared accesses seen in debug mode.
+func (w *World) AccessViolations() []AccessViolation {
+    return w.scheduler.Violations()
+}
+
+// Tick returns the number of times Update or RunFrame has run systems.
+func (w *World) Tick() uint64 {
+    return w.entityManager.Tick()
 }
 
 func (w *World) CreateEntity() Entity {
-    w.mu.Lock()
-    defer w.mu.Unlock()
-    
-    id := Entity(len(w.entities))
-    w.entities[id] = true
-    return id
+    return w.entityManager.CreateEntity()
 }
 
-func (w *World) AddComponent(entity Entity, component components.ComponentData) {
-    w.mu.Lock()
-    defer w.mu.Unlock()
-    
-    componentType := reflect.TypeOf(component)
-    if w.components[componentType] == nil {
-        w.components[componentType] = NewComponentArray()
+// EntityDestroyedEvent is published as "EntityDestroyed" after an entity and
+// all of its components have been removed from the world.
+type EntityDestroyedEvent struct {
+    Entity Entity
+}
+
+// EventName keeps EntityDestroyedEvent on the "EntityDestroyed" name.
+func (EntityDestroyedEvent) EventName() string {
+    return "EntityDestroyed"
+}
+
+func (e EntityDestroyedEvent) EventEntity() Entity {
+    return e.Entity
+}
+
+// DestroyEntity removes the entity and all of its components. Pooled
+// components are returned to their pool. Stale handles are ignored.
+func (w *World) DestroyEntity(entity Entity) {
+    w.DestroyEntities(entity)
+}
+
+// DestroyEntities destroys a batch of entities and then publishes one
+// "EntityDestroyed" event per entity that was actually alive.
+func (w *World) DestroyEntities(entities ...Entity) {
+    destroyed := make([]Entity, 0, len(entities))
+    for _, entity := range entities {
+        if w.entityManager.DestroyEntity(entity) {
+            destroyed = append(destroyed, entity)
+        }
     }
-    w.components[componentType].Add(entity, component)
+
+    w.publishDestroyed(destroyed)
 }
 
-func (w *World) RemoveComponent(entity Entity, componentType reflect.Type) {
-    w.mu.Lock()
-    defer w.mu.Unlock()
-    
-    if compArray, exists := w.components[componentType]; exists {
-        compArray.Remove(entity)
+// publishDestroyed publishes "EntityDestroyed" for each entity. Callers
+// publish after the storage is consistent so subscribers may call back into
+// the world.
+func (w *World) publishDestroyed(entities []Entity) {
+    for _, entity := range entities {
+        Publish(w.EventManager, EntityDestroyedEvent{Entity: entity})
     }
 }
 
+// InitializeComponentPool enables pooling for a component type. Components of
+// that type are returned to the pool when they are removed or their entity is
+// destroyed.
+func (w *World) InitializeComponentPool(componentType reflect.Type) {
+    w.entityManager.InitializeComponentPool(componentType)
+}
+
+// NewComponent returns a zeroed component of the given type, reusing a
+// pooled instance when one is available.
+func (w *World) NewComponent(componentType reflect.Type) components.ComponentData {
+    return w.entityManager.NewComponent(componentType)
+}
+
+// IsAlive reports whether the handle refers to a live entity of this world.
+func (w *World) IsAlive(entity Entity) bool {
+    return w.entityManager.IsAlive(entity)
+}
+
+func (w *World) AddComponent(entity Entity, component components.ComponentData) {
+    w.entityManager.AddComponent(entity, component)
+}
+
+func (w *World) UpdateComponent(entity Entity, component components.ComponentData) {
+    w.entityManager.UpdateComponent(entity, component)
+}
+
+func (w *World) RemoveComponent(entity Entity, componentType reflect.Type) {
+    w.entityManager.RemoveComponent(entity, componentType)
+}
+
 func (w *World) GetComponent(entity Entity, componentType reflect.Type) (components.ComponentData, bool) {
-    w.mu.RLock()
-    defer w.mu.RUnlock()
-    
-    if compArray, exists := w.components[componentType]; exists {
-        return compArray.Get(entity)
-    }
-    return nil, false
+    return w.entityManager.GetComponent(entity, componentType)
+}
+
+// Query returns every entity that has all of the given component types.
+func (w *World) Query(componentTypes ...reflect.Type) []Entity {
+    return w.entityManager.Query(componentTypes...)
+}
+
+// savedState is the JSON form of a world. Components are stored with the
+// entity that owns them and named through the components registry; Versions
+// records the schema version each component type was saved at, 1 if absent.
+type savedState struct {
+    Tick      uint64
+    Allocator allocatorState
+    Versions  map[string]int
+    Entities  []savedEntity
+    Resources map[string]json.RawMessage
+}
+
+type savedEntity struct {
+    Entity     Entity
+    Components []savedComponent
 }
 
+type savedComponent struct {
+    Type string
+    Data json.RawMessage
+}
+
+// SaveState encodes the world's entities, components and resources, along
+// with the allocator state so handles stay valid and are recycled the same
+// way after loading. Every component type must be registered with
+// components.Register. Resources are keyed by package path and type name.
 func (w *World) SaveState() ([]byte, error) {
-    w.mu.RLock()
-    defer w.mu.RUnlock()
-    
-    state := struct {
-        Entities   map[Entity]bool
-        Components map[string][]components.ComponentData
-    }{
-        Entities:   w.entities,
-        Components: make(map[string][]components.ComponentData),
-    }
-    
-    for compType, compArray := range w.components {
-        state.Components[compType.String()] = compArray.GetAll()
-    }
-    
+    em := w.entityManager
+    em.mu.RLock()
+    defer em.mu.RUnlock()
+
+    state := savedState{
+        Tick:      em.tick.Load(),
+        Allocator: em.entities.state(),
+        Versions:  make(map[string]int),
+    }
+
+    for _, entity := range em.entities.aliveEntities() {
+        saved := savedEntity{Entity: entity}
+        if record := em.record(entity); record != nil {
+            archetype := record.archetype
+            for i, compType := range archetype.componentTypes {
+                name, registered := components.TypeName(compType)
+                if !registered {
+                    return nil, fmt.Errorf("component type %v is not registered", compType)
+                }
+                data, err := json.Marshal(archetype.components[i][record.row])
+                if err != nil {
+                    return nil, fmt.Errorf("component %s of entity %d: %w", name, entity, err)
+                }
+                state.Versions[name], _ = components.Version(name)
+                saved.Components = append(saved.Components, savedComponent{Type: name, Data: data})
+            }
+        }
+        state.Entities = append(state.Entities, saved)
+    }
+
+    resources, err := w.encodeResources()
+    if err != nil {
+        return nil, err
+    }
+    state.Resources = resources
+
     return json.Marshal(state)
 }
 
-func (w *World) LoadState(data []byte) error {
-    var state struct {
-        Entities   map[Entity]bool
-        Components map[string][]components.ComponentData
+// encodeResources encodes every resource as JSON, keyed by resourceName.
+func (w *World) encodeResources() (map[string]json.RawMessage, error) {
+    result := make(map[string]json.RawMessage)
+    for name, resource := range w.resources.byName() {
+        data, err := json.Marshal(resource)
+        if err != nil {
+            return nil, fmt.Errorf("resource %s: %w", name, err)
+        }
+        result[name] = data
     }
-    
+    return result, nil
+}
+
+// LoadState replaces the world's entities and components with a saved
+// state, restoring every entity under its saved handle. The state is fully
+// decoded before the world is touched, so on error the world is unchanged.
+// Components saved at an older schema version are upgraded through the
+// migrations registered with components.RegisterMigration. Saved resources
+// are decoded into the resources of the same type already inserted in this
+// world; others are ignored.
+func (w *World) LoadState(data []byte) error {
+    var state savedState
     if err := json.Unmarshal(data, &state); err != nil {
         return err
     }
-    
-    w.mu.Lock()
-    defer w.mu.Unlock()
-    
-    w.entities = state.Entities
-    w.components = make(map[reflect.Type]*ComponentArray)
-    
-    for _, comps := range state.Components {
-        if len(comps) == 0 {
-            continue
+
+    loaded := &loadedState{
+        tick:      state.Tick,
+        allocator: state.Allocator,
+        entities:  make([]Entity, len(state.Entities)),
+        owned:     make([][]Component, len(state.Entities)),
+        resources: state.Resources,
+    }
+    for i, saved := range state.Entities {
+        loaded.entities[i] = saved.Entity
+        for _, comp := range saved.Components {
+            component, registered := components.New(comp.Type)
+            if !registered {
+                return fmt.Errorf("entity %d: unknown component type %q", saved.Entity, comp.Type)
+            }
+            version := state.Versions[comp.Type]
+            if version == 0 {
+                version = 1
+            }
+            if err := decodeComponent(component, comp.Type, version, comp.Data); err != nil {
+                return fmt.Errorf("entity %d: %w", saved.Entity, err)
+            }
+            loaded.owned[i] = append(loaded.owned[i], component)
+        }
+    }
+    return w.load(loaded)
+}
+
+// decodeComponent decodes JSON saved at a schema version into component,
+// migrating it first if the version is not the current one.
+func decodeComponent(component Component, name string, version int, data []byte) error {
+    if current, _ := components.Version(name); version != current {
+        var fields map[string]any
+        if err := json.Unmarshal(data, &fields); err != nil {
+            return fmt.Errorf("component %s: %w", name, err)
+        }
+        if err := components.Migrate(name, version, fields); err != nil {
+            return err
         }
-        compType := reflect.TypeOf(comps[0])
-        compArray := NewComponentArray()
-        for _, comp := range comps {
-            compArray.Add(Entity(len(compArray.Data)), comp)
+        migrated, err := json.Marshal(fields)
+        if err != nil {
+            return fmt.Errorf("component %s: %w", name, err)
         }
-        w.components[compType] = compArray
+        data = migrated
+    }
+    if err := json.Unmarshal(data, component); err != nil {
+        return fmt.Errorf("component %s: %w", name, err)
     }
-    
     return nil
-}
\ No newline at end of file
+}
+
+// loadedState is a decoded world, whatever format it was read from.
+// owned[i] holds the components of entities[i].
+type loadedState struct {
+    tick      uint64
+    allocator allocatorState
+    entities  []Entity
+    owned     [][]Component
+    resources map[string]json.RawMessage
+}
+
+// load validates a decoded state and replaces the world with it.
+func (w *World) load(state *loadedState) error {
+    if err := state.allocator.validate(); err != nil {
+        return err
+    }
+    live := newEntityAllocator()
+    live.restore(state.allocator)
+    for _, entity := range state.entities {
+        if !live.isAlive(entity) {
+            return fmt.Errorf("entity %d is not alive in the saved allocator", entity)
+        }
+    }
+
+    resources := w.resources.byName()
+    decoded := make(map[string]reflect.Value)
+    for name, raw := range state.resources {
+        if ptr, exists := resources[name]; exists {
+            value := reflect.New(reflect.TypeOf(ptr).Elem())
+            if err := json.Unmarshal(raw, value.Interface()); err != nil {
+                return fmt.Errorf("resource %s: %w", name, err)
+            }
+            decoded[name] = value.Elem()
+        }
+    }
+
+    em := w.entityManager
+    em.lock()
+    em.reset(state.allocator)
+    em.tick.Store(state.tick)
+    for i, entity := range state.entities {
+        record := em.record(entity)
+        for _, component := range state.owned[i] {
+            em.insert(record, reflect.TypeOf(component), component)
+        }
+    }
+    em.mu.Unlock()
+
+    for name, value := range decoded {
+        reflect.ValueOf(resources[name]).Elem().Set(value)
+    }
+    // Handles now name the loaded entities, whatever they named before.
+    w.EventManager.dropScoped()
+
+    // The journal cannot express a wholesale replacement; start over from
+    // the loaded state.
+    if w.journal != nil {
+        return w.journal.Checkpoint()
+    }
+    return nil
+}
@@ -0,0 +1,289 @@
+package ecs
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AMMPTT/strux/pkg/components"
+)
+
+type testConfig struct {
+	Rate  float32
+	Label string
+}
+
+// populatedWorld builds a world with recycled slots, entities with and
+// without components, and a resource.
+func populatedWorld(t *testing.T) (*World, []Entity) {
+	t.Helper()
+
+	w := NewWorld()
+	w.InsertResource(testConfig{Rate: 2.5, Label: "saved"})
+
+	var entities []Entity
+	for i := 0; i < 6; i++ {
+		entities = append(entities, w.CreateEntity())
+	}
+	w.DestroyEntity(entities[1])
+	w.DestroyEntity(entities[4])
+	entities = append(entities, w.CreateEntity()) // reuses slot 1
+
+	w.AddComponent(entities[0], &components.Lung{State: components.Inhale, Capacity: 1, Volume: 0.25})
+	w.AddComponent(entities[0], &components.Mouth{IsOpen: true})
+	w.AddComponent(entities[2], &components.Lung{State: components.Exhale, Capacity: 3, Volume: 2})
+	w.AddComponent(entities[3], &components.Mouth{})
+	w.AddComponent(entities[6], &components.Mouth{IsOpen: true})
+	w.Update(0.5)
+	return w, entities
+}
+
+func componentsOf(w *World, entity Entity) map[reflect.Type]components.ComponentData {
+	result := make(map[reflect.Type]components.ComponentData)
+	for _, t := range []reflect.Type{TypeOf[*components.Lung](), TypeOf[*components.Mouth]()} {
+		if component, ok := w.GetComponent(entity, t); ok {
+			result[t] = component
+		}
+	}
+	return result
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	original, entities := populatedWorld(t)
+	data, err := original.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	loaded := NewWorld()
+	loaded.InsertResource(testConfig{})
+	if err := loaded.LoadState(data); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	for _, entity := range entities {
+		if original.IsAlive(entity) != loaded.IsAlive(entity) {
+			t.Fatalf("entity %d: alive %v, want %v", entity, loaded.IsAlive(entity), original.IsAlive(entity))
+		}
+		want, got := componentsOf(original, entity), componentsOf(loaded, entity)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("entity %d: components %v, want %v", entity, got, want)
+		}
+	}
+	if config, _ := Resource[testConfig](loaded); config != (testConfig{Rate: 2.5, Label: "saved"}) {
+		t.Errorf("resource = %+v", config)
+	}
+	if loaded.Tick() != original.Tick() {
+		t.Errorf("tick = %d, want %d", loaded.Tick(), original.Tick())
+	}
+
+	// Handles are recycled the same way after loading.
+	for i := 0; i < 3; i++ {
+		if want, got := original.CreateEntity(), loaded.CreateEntity(); want != got {
+			t.Fatalf("CreateEntity after load = %d, want %d", got, want)
+		}
+	}
+
+	resaved, err := loaded.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState after load: %v", err)
+	}
+	saved, _ := original.SaveState()
+	if !bytes.Equal(saved, resaved) {
+		t.Errorf("state changed across a round trip:\n%s\n%s", saved, resaved)
+	}
+}
+
+func TestLoadStateReplacesWorld(t *testing.T) {
+	original, _ := populatedWorld(t)
+	data, err := original.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	w := NewWorld()
+	var stale []Entity
+	for i := 0; i < 10; i++ {
+		entity := w.CreateEntity()
+		w.AddComponent(entity, &components.Lung{Capacity: 9})
+		stale = append(stale, entity)
+	}
+	if err := w.LoadState(data); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	lungs := NewQuery1[*components.Lung](w).query.Entities()
+	if len(lungs) != 2 {
+		t.Errorf("%d entities with a Lung after load, want 2", len(lungs))
+	}
+	// The saved allocator has six slots, so handles past them are gone.
+	for _, entity := range stale[6:] {
+		if w.IsAlive(entity) {
+			t.Errorf("entity %d from before the load is still alive", entity)
+		}
+	}
+}
+
+func TestLoadStateUnknownComponentLeavesWorldUnchanged(t *testing.T) {
+	original, _ := populatedWorld(t)
+	data, err := original.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	data = bytes.Replace(data, []byte(`"Type":"Mouth"`), []byte(`"Type":"Nose"`), 1)
+
+	w := NewWorld()
+	entity := w.CreateEntity()
+	w.AddComponent(entity, &components.Mouth{IsOpen: true})
+
+	err = w.LoadState(data)
+	if err == nil || !strings.Contains(err.Error(), `"Nose"`) {
+		t.Fatalf("LoadState error = %v, want unknown component type", err)
+	}
+	if mouth, ok := Get[*components.Mouth](w, entity); !ok || !mouth.IsOpen {
+		t.Errorf("world changed by a failed load")
+	}
+}
+
+func TestSaveStateUnregisteredComponent(t *testing.T) {
+	w := NewWorld()
+	w.AddComponent(w.CreateEntity(), &unregistered{})
+	if _, err := w.SaveState(); err == nil {
+		t.Fatal("SaveState succeeded with an unregistered component type")
+	}
+}
+
+type unregistered struct{}
+
+func (*unregistered) IsComponentData() {}
+
+// testBreath is at schema version 2: version 1 had no Depth.
+type testBreath struct {
+	Rate  float32
+	Depth float32
+}
+
+func (*testBreath) IsComponentData() {}
+
+// testSigh is at schema version 3 but has no migration from version 1.
+type testSigh struct{ Length float32 }
+
+func (*testSigh) IsComponentData() {}
+
+func init() {
+	components.RegisterVersion("testBreath", 2, func() components.ComponentData { return &testBreath{} })
+	components.RegisterMigration("testBreath", 1, func(fields map[string]any) error {
+		rate, _ := fields["Rate"].(float64)
+		fields["Depth"] = rate / 10
+		return nil
+	})
+	components.RegisterVersion("testSigh", 3, func() components.ComponentData { return &testSigh{} })
+	components.RegisterMigration("testSigh", 2, func(fields map[string]any) error { return nil })
+}
+
+func TestLoadStateMigratesOldVersions(t *testing.T) {
+	entity := NewEntity(0, 0)
+	data := fmt.Sprintf(`{
+		"Allocator": {"Versions": "AA==", "Free": []},
+		"Versions": {"testBreath": 1},
+		"Entities": [{"Entity": %d, "Components": [{"Type": "testBreath", "Data": {"Rate": 12}}]}]
+	}`, entity)
+
+	w := NewWorld()
+	if err := w.LoadState([]byte(data)); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	breath, ok := Get[*testBreath](w, entity)
+	if !ok || *breath != (testBreath{Rate: 12, Depth: 1.2}) {
+		t.Fatalf("migrated component = %+v, want Rate 12 and Depth 1.2", breath)
+	}
+
+	saved, err := w.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	if !strings.Contains(string(saved), `"testBreath":2`) {
+		t.Errorf("save does not record the current version: %s", saved)
+	}
+}
+
+func TestLoadStateWithoutMigrationPath(t *testing.T) {
+	data := `{
+		"Allocator": {"Versions": "AA==", "Free": []},
+		"Versions": {"testSigh": 1},
+		"Entities": [{"Entity": 0, "Components": [{"Type": "testSigh", "Data": {"Length": 2}}]}]
+	}`
+
+	err := NewWorld().LoadState([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "testSigh") || !strings.Contains(err.Error(), "version 1") {
+		t.Fatalf("LoadState error = %v, want missing migration for testSigh", err)
+	}
+}
+
+func TestDestroyEntityRemovesEveryComponent(t *testing.T) {
+	w, entities := populatedWorld(t)
+	w.DestroyEntity(entities[0])
+
+	if w.IsAlive(entities[0]) {
+		t.Fatal("destroyed entity is alive")
+	}
+	if got := componentsOf(w, entities[0]); len(got) != 0 {
+		t.Errorf("destroyed entity still has %v", got)
+	}
+	for _, componentType := range []reflect.Type{TypeOf[*components.Lung](), TypeOf[*components.Mouth]()} {
+		for _, entity := range w.Query(componentType) {
+			if entity == entities[0] {
+				t.Errorf("destroyed entity still matches a %v query", componentType)
+			}
+		}
+	}
+	// The other entities keep their components.
+	if lung, ok := Get[*components.Lung](w, entities[2]); !ok || lung.Capacity != 3 {
+		t.Errorf("entity %d lung = %v, %v", entities[2], lung, ok)
+	}
+}
+
+func TestDestroyEntityReturnsPooledComponents(t *testing.T) {
+	w := NewWorld()
+	w.InitializeComponentPool(lungType)
+	entity := w.CreateEntity()
+	lung := &components.Lung{State: components.Exhale, Capacity: 4, Volume: 2}
+	w.AddComponent(entity, lung)
+	w.DestroyEntity(entity)
+
+	if *lung != (components.Lung{}) {
+		t.Errorf("pooled component not zeroed: %+v", *lung)
+	}
+	if reused := w.NewComponent(lungType); reused != lung {
+		t.Error("NewComponent did not reuse the pooled component")
+	}
+}
+
+func TestDestroyEntitiesPublishesOncePerLiveEntity(t *testing.T) {
+	w, entities := populatedWorld(t)
+	var typed []Entity
+	Subscribe(w.EventManager, func(event EntityDestroyedEvent) {
+		typed = append(typed, event.Entity)
+	})
+	var named []interface{}
+	w.EventManager.Subscribe("EntityDestroyed", func(data interface{}) {
+		named = append(named, data)
+	})
+
+	// entities[1] is stale: its slot was reused by entities[6].
+	w.DestroyEntities(entities[0], entities[0], entities[1], entities[3])
+	w.DestroyEntity(entities[3])
+
+	if want := []Entity{entities[0], entities[3]}; !reflect.DeepEqual(typed, want) {
+		t.Errorf("EntityDestroyed published for %v, want %v", typed, want)
+	}
+	want := []interface{}{EntityDestroyedEvent{Entity: entities[0]}, EntityDestroyedEvent{Entity: entities[3]}}
+	if !reflect.DeepEqual(named, want) {
+		t.Errorf("string subscriber received %v, want %v", named, want)
+	}
+	if !w.IsAlive(entities[6]) {
+		t.Error("destroying a stale handle destroyed the slot's current entity")
+	}
+}
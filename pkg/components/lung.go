@@ -15,4 +15,8 @@ type Lung struct {
     Volume   float32
 }
 
-func (l *Lung) IsComponentData() {}
\ No newline at end of file
+func (l *Lung) IsComponentData() {}
+
+func init() {
+    Register("Lung", func() ComponentData { return &Lung{} })
+}
@@ -5,4 +5,8 @@ type Mouth struct {
     IsOpen bool
 }
 
-func (m *Mouth) IsComponentData() {}
\ No newline at end of file
+func (m *Mouth) IsComponentData() {}
+
+func init() {
+    Register("Mouth", func() ComponentData { return &Mouth{} })
+}
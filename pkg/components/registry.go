@@ -0,0 +1,180 @@
+// pkg/components/registry.go
+
+package components
+
+import (
+    "fmt"
+    "reflect"
+    "sort"
+    "sync"
+)
+
+// Constructor returns a new, zero-valued component.
+type Constructor func() ComponentData
+
+// Migration upgrades a saved component from one schema version to the next.
+// fields holds the saved field values by name, decoded the way encoding/json
+// decodes into a map: numbers are float64. It is modified in place.
+type Migration func(fields map[string]any) error
+
+type registration struct {
+    constructor Constructor
+    version     int
+    migrations  map[int]Migration // keyed by the version they upgrade from
+}
+
+// The registry maps stable type names to constructors so saved states can
+// name their component types and rebuild concrete values when loading, and
+// keeps each type's schema version and the migrations between versions.
+var registry = struct {
+    mu     sync.RWMutex
+    byName map[string]*registration
+    byType map[reflect.Type]string
+}{
+    byName: make(map[string]*registration),
+    byType: make(map[reflect.Type]string),
+}
+
+// Register makes a component type known under name at schema version 1.
+// Component packages call it from init. It panics if the name or the type is
+// already registered.
+func Register(name string, constructor Constructor) {
+    RegisterVersion(name, 1, constructor)
+}
+
+// RegisterVersion is Register for a type whose layout has changed since it
+// was first saved. Bump the version whenever a saved field is added, removed
+// or reinterpreted, and register a Migration from the previous version.
+func RegisterVersion(name string, version int, constructor Constructor) {
+    if version < 1 {
+        panic(fmt.Sprintf("components: %q has invalid version %d", name, version))
+    }
+    componentType := reflect.TypeOf(constructor())
+
+    registry.mu.Lock()
+    defer registry.mu.Unlock()
+
+    if _, exists := registry.byName[name]; exists {
+        panic(fmt.Sprintf("components: %q registered twice", name))
+    }
+    if other, exists := registry.byType[componentType]; exists {
+        panic(fmt.Sprintf("components: %v already registered as %q", componentType, other))
+    }
+    registry.byName[name] = &registration{
+        constructor: constructor,
+        version:     version,
+        migrations:  make(map[int]Migration),
+    }
+    registry.byType[componentType] = name
+}
+
+// RegisterMigration registers the upgrade of the named type from version
+// from to from+1. The type must already be registered.
+func RegisterMigration(name string, from int, migration Migration) {
+    registry.mu.Lock()
+    defer registry.mu.Unlock()
+
+    entry, exists := registry.byName[name]
+    if !exists {
+        panic(fmt.Sprintf("components: migration for unregistered %q", name))
+    }
+    if _, exists := entry.migrations[from]; exists {
+        panic(fmt.Sprintf("components: %q migration from version %d registered twice", name, from))
+    }
+    entry.migrations[from] = migration
+}
+
+// New returns a zero-valued component of the named type.
+func New(name string) (ComponentData, bool) {
+    registry.mu.RLock()
+    entry, exists := registry.byName[name]
+    registry.mu.RUnlock()
+
+    if !exists {
+        return nil, false
+    }
+    return entry.constructor(), true
+}
+
+// Version returns the current schema version of the named type.
+func Version(name string) (int, bool) {
+    registry.mu.RLock()
+    defer registry.mu.RUnlock()
+
+    entry, exists := registry.byName[name]
+    if !exists {
+        return 0, false
+    }
+    return entry.version, true
+}
+
+// CheckMigration reports an error naming the component unless fields saved
+// at version from can be brought up to the current version.
+func CheckMigration(name string, from int) error {
+    _, err := migrationPath(name, from)
+    return err
+}
+
+// Migrate upgrades fields saved at version from to the current version of
+// the named type, one version at a time.
+func Migrate(name string, from int, fields map[string]any) error {
+    path, err := migrationPath(name, from)
+    if err != nil {
+        return err
+    }
+    for i, migration := range path {
+        if err := migration(fields); err != nil {
+            return fmt.Errorf("component %s: migrating from version %d: %w", name, from+i, err)
+        }
+    }
+    return nil
+}
+
+func migrationPath(name string, from int) ([]Migration, error) {
+    registry.mu.RLock()
+    defer registry.mu.RUnlock()
+
+    entry, exists := registry.byName[name]
+    if !exists {
+        return nil, fmt.Errorf("unknown component type %q", name)
+    }
+    if from > entry.version {
+        return nil, fmt.Errorf("component %s: saved at version %d, newer than the supported version %d", name, from, entry.version)
+    }
+    var path []Migration
+    for version := from; version < entry.version; version++ {
+        migration, exists := entry.migrations[version]
+        if !exists {
+            return nil, fmt.Errorf("component %s: no migration from version %d to %d", name, version, version+1)
+        }
+        path = append(path, migration)
+    }
+    return path, nil
+}
+
+// NameOf returns the name a component's type was registered under.
+func NameOf(component ComponentData) (string, bool) {
+    return TypeName(reflect.TypeOf(component))
+}
+
+// TypeName returns the name a component type was registered under.
+func TypeName(componentType reflect.Type) (string, bool) {
+    registry.mu.RLock()
+    defer registry.mu.RUnlock()
+
+    name, exists := registry.byType[componentType]
+    return name, exists
+}
+
+// Registered returns every registered name in sorted order.
+func Registered() []string {
+    registry.mu.RLock()
+    defer registry.mu.RUnlock()
+
+    names := make([]string, 0, len(registry.byName))
+    for name := range registry.byName {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}